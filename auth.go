@@ -0,0 +1,144 @@
+package gnmi
+
+import (
+	"context"
+
+	"github.com/freeconf/yang/fc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is who is calling the gNMI server.  Handlers and the FreeCONF nodes
+// behind them can find it in the context of each request's selection
+//
+//	if id, valid := gnmi.IdentityFromContext(sel.Context); valid {
+//	   ...
+//	}
+type Identity struct {
+
+	// Username authenticated from gRPC metadata
+	Username string
+
+	// Subject of the verified client certificate
+	Subject string
+
+	// DNS, email, URI and IP subject alternative names of the verified client
+	// certificate
+	SANs []string
+}
+
+type identityKey struct{}
+
+// WithIdentity attaches caller identity to a context
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext is the caller identity if one was established
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, valid := ctx.Value(identityKey{}).(*Identity)
+	return id, valid && id != nil
+}
+
+// peerIdentity extracts identity from the client certificate verified during
+// the TLS handshake
+func peerIdentity(ctx context.Context) *Identity {
+	p, valid := peer.FromContext(ctx)
+	if !valid || p.AuthInfo == nil {
+		return nil
+	}
+	tlsInfo, valid := p.AuthInfo.(credentials.TLSInfo)
+	if !valid || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	id := &Identity{
+		Subject: cert.Subject.String(),
+	}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	id.SANs = append(id.SANs, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		id.SANs = append(id.SANs, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.SANs = append(id.SANs, ip.String())
+	}
+	return id
+}
+
+func withPeerIdentity(ctx context.Context) context.Context {
+	if id := peerIdentity(ctx); id != nil {
+		return WithIdentity(ctx, id)
+	}
+	return ctx
+}
+
+func unaryIdentity(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(withPeerIdentity(ctx), req)
+}
+
+func streamIdentity(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withPeerIdentity(ss.Context())})
+}
+
+// contextStream lets interceptors replace the context of a stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Server) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate checks username and password metadata when there is an
+// authenticator and adds username to caller identity
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	auth := s.Authenticator()
+	if auth == nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	username := firstMetadata(md, "username")
+	password := firstMetadata(md, "password")
+	if username == "" {
+		return nil, status.Error(codes.Unauthenticated, "no username given")
+	}
+	if err := auth.Authenticate(ctx, username, password); err != nil {
+		fc.Debug.Printf("authentication failed for %s. %s", username, err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	id := &Identity{}
+	if existing, valid := IdentityFromContext(ctx); valid {
+		*id = *existing
+	}
+	id.Username = username
+	return WithIdentity(ctx, id), nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package gnmi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/nodeutil"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeerIdentity(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "joe"},
+		DNSNames: []string{"joe.example.com"},
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			},
+		},
+	})
+	var actual *Identity
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		dev := newTestDevice(map[string]interface{}{})
+		sel, err := selectPath(dev, ctx, &pb_gnmi.Path{Origin: "x"})
+		fc.RequireEqual(t, nil, err)
+		actual, _ = IdentityFromContext(sel.Context)
+		return nil, nil
+	}
+	_, err := unaryIdentity(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	fc.AssertEqual(t, nil, err)
+	fc.RequireEqual(t, true, actual != nil)
+	fc.AssertEqual(t, "CN=joe", actual.Subject)
+	fc.AssertEqual(t, "joe.example.com", actual.SANs[0])
+
+	t.Run("none", func(t *testing.T) {
+		_, valid := IdentityFromContext(withPeerIdentity(context.Background()))
+		fc.AssertEqual(t, false, valid)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := device.New(InternalYPath)
+	s := NewServer(d)
+	ctx := context.Background()
+
+	// no authentication configured
+	_, err := s.authenticate(ctx)
+	fc.AssertEqual(t, nil, err)
+
+	hash, err := HashPassword("secret")
+	fc.RequireEqual(t, nil, err)
+	b, err := d.Browser("fc-gnmi")
+	fc.RequireEqual(t, nil, err)
+	cfg, err := nodeutil.ReadJSON(`{"authentication":{"user":[{"name":"joe","passwordHash":"` + hash + `"}]}}`)
+	fc.RequireEqual(t, nil, err)
+	fc.RequireEqual(t, nil, b.Root().UpsertFrom(cfg))
+	fc.AssertEqual(t, []string{"joe"}, s.Users().Usernames())
+
+	tests := []struct {
+		creds    []string
+		expected codes.Code
+	}{
+		{creds: []string{"username", "joe", "password", "secret"}, expected: codes.OK},
+		{creds: []string{"username", "joe", "password", "bad"}, expected: codes.Unauthenticated},
+		{creds: []string{"username", "mary", "password", "secret"}, expected: codes.Unauthenticated},
+		{creds: nil, expected: codes.Unauthenticated},
+	}
+	for _, test := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(test.creds...))
+		actual, err := s.authenticate(ctx)
+		fc.AssertEqual(t, test.expected, status.Code(err))
+		if err == nil {
+			id, _ := IdentityFromContext(actual)
+			fc.AssertEqual(t, "joe", id.Username)
+		}
+	}
+}
+
+func TestPasswordHashHidden(t *testing.T) {
+	d := device.New(InternalYPath)
+	s := NewServer(d)
+	hash, err := HashPassword("secret")
+	fc.RequireEqual(t, nil, err)
+	b, err := d.Browser("fc-gnmi")
+	fc.RequireEqual(t, nil, err)
+	cfg, err := nodeutil.ReadJSON(`{"authentication":{"user":[{"name":"joe","passwordHash":"` + hash + `"}]}}`)
+	fc.RequireEqual(t, nil, err)
+	fc.RequireEqual(t, nil, b.Root().UpsertFrom(cfg))
+
+	auth := &pb_gnmi.Path{Origin: "fc-gnmi", Elem: []*pb_gnmi.PathElem{{Name: "authentication"}}}
+	resp, err := get(d, context.TODO(), &pb_gnmi.GetRequest{Path: []*pb_gnmi.Path{auth}})
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, `{"user":[{"name":"joe"}]}`, string(resp.Notification[0].Update[0].Val.GetJsonVal()))
+
+	// rollback still puts hash back
+	joe := &pb_gnmi.Path{Origin: "fc-gnmi", Elem: []*pb_gnmi.PathElem{
+		{Name: "authentication"},
+		{Name: "user", Key: map[string]string{"name": "joe"}},
+	}}
+	bogus := &pb_gnmi.Path{Origin: "fc-gnmi", Elem: []*pb_gnmi.PathElem{{Name: "bogus"}}}
+	val := &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(`{"name":"joe"}`)}}
+	_, err = set(d, context.TODO(), &pb_gnmi.SetRequest{
+		Replace: []*pb_gnmi.Update{{Path: joe, Val: val}},
+		Update:  []*pb_gnmi.Update{{Path: bogus, Val: val}},
+	})
+	fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+	actual, _ := s.Users().PasswordHash("joe")
+	fc.AssertEqual(t, hash, actual)
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, true, strings.HasPrefix(hash, "pbkdf2-sha256$600000$"))
+	fc.AssertEqual(t, true, checkPassword(hash, "secret"))
+	fc.AssertEqual(t, false, checkPassword(hash, "bad"))
+
+	// hashes keep working after work factor changes
+	defer func(orig int) { PasswordIterations = orig }(PasswordIterations)
+	PasswordIterations = 1000
+	fc.AssertEqual(t, true, checkPassword(hash, "secret"))
+
+	// unknown algorithms are rejected
+	fc.AssertEqual(t, false, checkPassword("sha256$00$00", "secret"))
+}
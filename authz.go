@@ -0,0 +1,246 @@
+package gnmi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Permission a role has on a path and everything under it
+type Permission int
+
+const (
+	PermNone Permission = iota
+	PermRead
+	PermFull
+)
+
+// Rbac is role based access control over gNMI paths.  Configured thru fc-gnmi
+// authorization.  Once set on server, callers can only access paths granted by
+// the roles they are a member of.
+type Rbac struct {
+	Role map[string]*Role
+}
+
+// Role grants access to paths to a set of members
+type Role struct {
+	Name string
+
+	// Usernames, certificate subjects or certificate subject alternative names
+	Member []string
+
+	// Rules by path
+	Rule map[string]*Rule
+}
+
+// Rule grants access to a path which is module name followed by data path
+// without list keys.  Examples:  x, x/interfaces, x/system/clock
+type Rule struct {
+	Path   string
+	Access Permission
+}
+
+func (r *Rbac) clone() *Rbac {
+	copy := &Rbac{
+		Role: make(map[string]*Role, len(r.Role)),
+	}
+	for name, role := range r.Role {
+		roleCopy := &Role{
+			Name:   role.Name,
+			Member: append([]string{}, role.Member...),
+			Rule:   make(map[string]*Rule, len(role.Rule)),
+		}
+		for path, rule := range role.Rule {
+			ruleCopy := *rule
+			roleCopy.Rule[path] = &ruleCopy
+		}
+		copy.Role[name] = roleCopy
+	}
+	return copy
+}
+
+func (role *Role) isMember(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+	for _, m := range role.Member {
+		if m == "" {
+			continue
+		}
+		if m == id.Username || m == id.Subject {
+			return true
+		}
+		for _, san := range id.SANs {
+			if m == san {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// access is what a single caller is allowed according to their roles
+type access struct {
+	rules []*Rule
+}
+
+func (r *Rbac) access(id *Identity) *access {
+	a := &access{}
+	for _, role := range r.Role {
+		if role.isMember(id) {
+			for _, rule := range role.Rule {
+				a.rules = append(a.rules, rule)
+			}
+		}
+	}
+	return a
+}
+
+// permission from the most specific rule matching path
+func (a *access) permission(p *node.Path) Permission {
+	target := metaPath(p)
+	perm := PermNone
+	longest := -1
+	for _, rule := range a.rules {
+		if !isPathPrefix(rule.Path, target) {
+			continue
+		}
+		if len(rule.Path) > longest {
+			longest = len(rule.Path)
+			perm = rule.Access
+		} else if len(rule.Path) == longest && rule.Access > perm {
+			perm = rule.Access
+		}
+	}
+	return perm
+}
+
+// allowedBelow is true if any rule grants at least perm somewhere under path
+func (a *access) allowedBelow(p *node.Path, perm Permission) bool {
+	target := metaPath(p)
+	for _, rule := range a.rules {
+		if rule.Access >= perm && rule.Path != target && isPathPrefix(target, rule.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// metaPath is like node.Path.String but w/o list keys
+func metaPath(p *node.Path) string {
+	var segs []string
+	for ; p != nil; p = p.Parent {
+		if p.Meta != nil {
+			segs = append(segs, p.Meta.Ident())
+		}
+	}
+	for i, j := 0, len(segs)-1; i < j; i, j = i+1, j-1 {
+		segs[i], segs[j] = segs[j], segs[i]
+	}
+	return strings.Join(segs, "/")
+}
+
+type accessKey struct{}
+
+func withAccess(ctx context.Context, a *access) context.Context {
+	return context.WithValue(ctx, accessKey{}, a)
+}
+
+func accessFromContext(ctx context.Context) *access {
+	if ctx == nil {
+		return nil
+	}
+	a, _ := ctx.Value(accessKey{}).(*access)
+	return a
+}
+
+// checkAccess ensures caller has at least perm on selection or, when partial is
+// true, on something under it. Partial access is enough for reads and merges
+// because the access constraint on the selection prunes or rejects the rest.
+func checkAccess(sel *node.Selection, perm Permission, partial bool) error {
+	a := accessFromContext(sel.Context)
+	if a == nil {
+		return nil
+	}
+	if a.permission(sel.Path) >= perm {
+		return nil
+	}
+	if partial && a.allowedBelow(sel.Path, perm) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "access denied to %s", sel.Path)
+}
+
+// secureSelection adds access constraint to a selection if caller is subject
+// to authorization
+func secureSelection(sel *node.Selection) {
+	if a := accessFromContext(sel.Context); a != nil {
+		sel.Constraints.AddConstraint("gnmi-access", 0, 0, accessConstraint{access: a})
+	}
+}
+
+// accessConstraint hides data caller cannot read and rejects edits caller
+// cannot write. Navigation is always allowed, targets are checked w/checkAccess
+type accessConstraint struct {
+	access *access
+}
+
+func (c accessConstraint) check(p *node.Path, write bool) (bool, error) {
+	if write {
+		if c.access.permission(p) >= PermFull {
+			return true, nil
+		}
+		return false, status.Errorf(codes.PermissionDenied, "write access denied to %s", p)
+	}
+	if c.access.permission(p) >= PermRead || c.access.allowedBelow(p, PermRead) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c accessConstraint) CheckContainerPreConstraints(r *node.ChildRequest) (bool, error) {
+	if r.IsNavigation() {
+		return true, nil
+	}
+	p := &node.Path{Parent: r.Selection.Path, Meta: r.Meta}
+	return c.check(p, r.New || r.Delete)
+}
+
+func (c accessConstraint) CheckListPreConstraints(r *node.ListRequest) (bool, error) {
+	if r.IsNavigation() {
+		return true, nil
+	}
+	return c.check(r.Selection.Path, r.New || r.Delete)
+}
+
+func (c accessConstraint) CheckFieldPreConstraints(r *node.FieldRequest, hnd *node.ValueHandle) (bool, error) {
+	if r.IsNavigation() {
+		return true, nil
+	}
+	p := &node.Path{Parent: r.Selection.Path, Meta: r.Meta.(meta.Definition)}
+	return c.check(p, r.Write)
+}
+
+func (s *Server) unaryAuthz(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(s.authorize(ctx), req)
+}
+
+func (s *Server) streamAuthz(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: s.authorize(ss.Context())})
+}
+
+// authorize attaches what caller may access when there is an authorization
+// policy
+func (s *Server) authorize(ctx context.Context) context.Context {
+	rbac := s.Authorization()
+	if rbac == nil {
+		return ctx
+	}
+	id, _ := IdentityFromContext(ctx)
+	return withAccess(ctx, rbac.access(id))
+}
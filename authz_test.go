@@ -0,0 +1,101 @@
+package gnmi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/nodeutil"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorization(t *testing.T) {
+	d := device.New(InternalYPath)
+	s := NewServer(d)
+	b, err := d.Browser("fc-gnmi")
+	fc.RequireEqual(t, nil, err)
+	cfg, err := nodeutil.ReadJSON(`{"authorization":{"role":[
+		{"name":"ops","member":["joe"],"rule":[{"path":"x/users","access":"read"}]},
+		{"name":"admin","member":["CN=mary"],"rule":[{"path":"x","access":"full"},{"path":"x/me/address","access":"none"}]}
+	]}}`)
+	fc.RequireEqual(t, nil, err)
+	fc.RequireEqual(t, nil, b.Root().UpsertFrom(cfg))
+	rbac := s.Authorization()
+	fc.RequireEqual(t, true, rbac != nil)
+	fc.AssertEqual(t, PermRead, rbac.Role["ops"].Rule["x/users"].Access)
+
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":    "joe",
+			"skill":   "manager",
+			"address": "123 mockingbird lane.",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+		},
+	}
+	dev := newTestDevice(data)
+	drv := newDriver(dev, nil, &commitMgrs{})
+	ops := withAccess(context.Background(), rbac.access(&Identity{Username: "joe"}))
+	admin := withAccess(context.Background(), rbac.access(&Identity{Subject: "CN=mary"}))
+	nobody := withAccess(context.Background(), rbac.access(nil))
+
+	get := func(ctx context.Context, p *pb_gnmi.Path) (string, error) {
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
+			Path:      []*pb_gnmi.Path{p},
+		})
+		if err != nil {
+			return "", err
+		}
+		return string(resp.Notification[0].Update[0].Val.GetJsonVal()), nil
+	}
+	mePath := &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}}
+
+	t.Run("prune", func(t *testing.T) {
+		actual, err := get(ops, nil)
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, `{"users":[{"name":"mary","skill":"welder"}]}`, actual)
+
+		actual, err = get(admin, mePath)
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, `{"name":"joe","skill":"manager"}`, actual)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		_, err := get(ops, mePath)
+		fc.AssertEqual(t, codes.PermissionDenied, status.Code(err))
+		_, err = get(nobody, nil)
+		fc.AssertEqual(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("set", func(t *testing.T) {
+		req := &pb_gnmi.SetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Update: []*pb_gnmi.Update{
+				{
+					Path: mePath,
+					Val: &pb_gnmi.TypedValue{
+						Value: &pb_gnmi.TypedValue_JsonVal{
+							JsonVal: []byte(`{"name":"charlie"}`),
+						},
+					},
+				},
+			},
+		}
+		_, err := drv.Set(ops, req)
+		fc.AssertEqual(t, codes.PermissionDenied, status.Code(err))
+		_, err = drv.Set(admin, req)
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, "charlie", data["me"].(map[string]interface{})["name"])
+
+		req.Update[0].Val.Value = &pb_gnmi.TypedValue_JsonVal{
+			JsonVal: []byte(`{"address":"1 main st."}`),
+		}
+		_, err = drv.Set(admin, req)
+		fc.AssertEqual(t, codes.PermissionDenied, status.Code(err))
+	})
+}
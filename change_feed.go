@@ -0,0 +1,63 @@
+package gnmi
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/freeconf/yang/node"
+)
+
+// changeFeed fans out edits made thru a browser to any number of listeners.
+// Browser trigger tables are not safe to alter while edits are in progress
+// so a single trigger is installed per browser and never removed, listeners
+// come and go here instead.
+type changeFeed struct {
+	mu        sync.Mutex
+	listeners *list.List
+}
+
+// changeListener is called from the goroutine making the edit
+type changeListener func(r node.NodeRequest)
+
+var feedsMu sync.Mutex
+var feeds = make(map[*node.Browser]*changeFeed)
+
+func browserChangeFeed(b *node.Browser) *changeFeed {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	f, found := feeds[b]
+	if !found {
+		f = &changeFeed{listeners: list.New()}
+		b.Triggers.Install(&node.Trigger{
+			OnEnd: func(_ *node.Trigger, r node.NodeRequest) error {
+				f.publish(r)
+				return nil
+			},
+		})
+		feeds[b] = f
+	}
+	return f
+}
+
+func (f *changeFeed) listen(l changeListener) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := f.listeners.PushBack(l)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.listeners.Remove(e)
+	}
+}
+
+func (f *changeFeed) publish(r node.NodeRequest) {
+	f.mu.Lock()
+	var ls []changeListener
+	for e := f.listeners.Front(); e != nil; e = e.Next() {
+		ls = append(ls, e.Value.(changeListener))
+	}
+	f.mu.Unlock()
+	for _, l := range ls {
+		l(r)
+	}
+}
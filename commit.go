@@ -0,0 +1,158 @@
+package gnmi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRollbackDuration is how long to wait for a confirm when commit does
+// not say
+var DefaultRollbackDuration = 10 * time.Minute
+
+// commitMgr implements gNMI commit confirmed extension.  Set requests w/a
+// commit are applied but rolled back unless confirmed in time. There can only
+// be one commit waiting for a confirm at a time.
+//
+//	https://github.com/openconfig/reference/blob/master/rpc/gnmi/gnmi-commit-confirmed.md
+type commitMgr struct {
+	mu      sync.Mutex
+	pending *pendingCommit
+}
+
+// commitMgrs keeps a commitMgr for server's own device and one for each target
+// so a commit waiting for confirm on one device does not hold up Sets to
+// others. Server owns these so commits waiting for confirm are not lost when
+// server is reconfigured.
+type commitMgrs struct {
+	local   commitMgr
+	mu      sync.Mutex
+	targets map[string]*commitMgr
+}
+
+func (c *commitMgrs) get(target string) *commitMgr {
+	if target == "" {
+		return &c.local
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.targets == nil {
+		c.targets = make(map[string]*commitMgr)
+	}
+	m, found := c.targets[target]
+	if !found {
+		m = &commitMgr{}
+		c.targets[target] = m
+	}
+	return m
+}
+
+type pendingCommit struct {
+	id    string
+	tx    *transaction
+	timer *time.Timer
+}
+
+func (m *commitMgr) set(d device.Device, ctx context.Context, req *pb_gnmi.SetRequest) (*pb_gnmi.SetResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	commit := commitExtension(req)
+	if commit == nil {
+		if m.pending != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "commit %s is waiting for confirm or cancel", m.pending.id)
+		}
+		return set(d, ctx, req)
+	}
+	if commit.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "commit id required")
+	}
+	if x, isCommit := commit.Action.(*gnmi_ext.Commit_Commit); isCommit {
+		return m.commit(d, ctx, req, commit.Id, x.Commit)
+	}
+	if hasOperations(req) {
+		return nil, status.Errorf(codes.InvalidArgument, "commit %s cannot have operations unless creating commit", commit.Id)
+	}
+	if m.pending == nil || m.pending.id != commit.Id {
+		return nil, status.Errorf(codes.FailedPrecondition, "no commit %s waiting", commit.Id)
+	}
+	switch x := commit.Action.(type) {
+	case *gnmi_ext.Commit_Confirm:
+		m.pending.timer.Stop()
+		m.pending = nil
+	case *gnmi_ext.Commit_Cancel:
+		m.pending.timer.Stop()
+		if err := m.rollback(); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not cancel commit %s. %s", commit.Id, err)
+		}
+	case *gnmi_ext.Commit_SetRollbackDuration:
+		m.pending.timer.Reset(rollbackDuration(x.SetRollbackDuration.GetRollbackDuration().AsDuration()))
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "commit %s has no action", commit.Id)
+	}
+	return &pb_gnmi.SetResponse{
+		Timestamp: time.Now().UnixNano(),
+	}, nil
+}
+
+func (m *commitMgr) commit(d device.Device, ctx context.Context, req *pb_gnmi.SetRequest, id string, r *gnmi_ext.CommitRequest) (*pb_gnmi.SetResponse, error) {
+	if m.pending != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "commit %s is already waiting for confirm or cancel", m.pending.id)
+	}
+	resp, tx, err := transact(d, ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	p := &pendingCommit{id: id, tx: tx}
+	p.timer = time.AfterFunc(rollbackDuration(r.GetRollbackDuration().AsDuration()), func() {
+		m.expire(p)
+	})
+	m.pending = p
+	return resp, nil
+}
+
+// expire rolls back commit that was not confirmed in time
+func (m *commitMgr) expire(p *pendingCommit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// confirmed or cancelled while waiting for lock
+	if m.pending != p {
+		return
+	}
+	fc.Debug.Printf("commit %s was not confirmed, rolling back", p.id)
+	if err := m.rollback(); err != nil {
+		fc.Err.Printf("could not roll back commit %s. %s", p.id, err)
+	}
+}
+
+func (m *commitMgr) rollback() error {
+	tx := m.pending.tx
+	m.pending = nil
+	return tx.rollback(context.Background())
+}
+
+func rollbackDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultRollbackDuration
+	}
+	return d
+}
+
+func commitExtension(req *pb_gnmi.SetRequest) *gnmi_ext.Commit {
+	for _, ext := range req.Extension {
+		if c := ext.GetCommit(); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+func hasOperations(req *pb_gnmi.SetRequest) bool {
+	return len(req.Delete) > 0 || len(req.Replace) > 0 || len(req.UnionReplace) > 0 || len(req.Update) > 0
+}
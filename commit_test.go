@@ -0,0 +1,110 @@
+package gnmi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/nodeutil"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestCommitConfirmed(t *testing.T) {
+	ctx := context.TODO()
+	newReq := func(c *gnmi_ext.Commit, name string) *pb_gnmi.SetRequest {
+		req := &pb_gnmi.SetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Extension: []*gnmi_ext.Extension{{
+				Ext: &gnmi_ext.Extension_Commit{Commit: c},
+			}},
+		}
+		if name != "" {
+			req.Update = []*pb_gnmi.Update{{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}, {Name: "name"}}},
+				Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: name}},
+			}}
+		}
+		return req
+	}
+	commit := func(id string, d time.Duration) *gnmi_ext.Commit {
+		return &gnmi_ext.Commit{Id: id, Action: &gnmi_ext.Commit_Commit{
+			Commit: &gnmi_ext.CommitRequest{RollbackDuration: durationpb.New(d)},
+		}}
+	}
+	confirm := func(id string) *gnmi_ext.Commit {
+		return &gnmi_ext.Commit{Id: id, Action: &gnmi_ext.Commit_Confirm{Confirm: &gnmi_ext.CommitConfirm{}}}
+	}
+	cancel := func(id string) *gnmi_ext.Commit {
+		return &gnmi_ext.Commit{Id: id, Action: &gnmi_ext.Commit_Cancel{Cancel: &gnmi_ext.CommitCancel{}}}
+	}
+	setup := func() (*driver, func() string) {
+		dev := newTestDevice(map[string]interface{}{
+			"me": map[string]interface{}{"name": "joe"},
+		})
+		b, _ := dev.Browser("x")
+		name := func() string {
+			actual, err := nodeutil.WriteJSON(b.Root())
+			fc.RequireEqual(t, nil, err)
+			return actual
+		}
+		return newDriver(dev, nil, &commitMgrs{}), name
+	}
+	joe := `{"me":{"name":"joe"}}`
+	barb := `{"me":{"name":"barb"}}`
+
+	t.Run("confirm", func(t *testing.T) {
+		drv, data := setup()
+		_, err := drv.Set(ctx, newReq(commit("c1", time.Minute), "barb"))
+		fc.RequireEqual(t, nil, err)
+		fc.AssertEqual(t, barb, data())
+
+		// nothing else until commit is confirmed or cancelled
+		_, err = drv.Set(ctx, newReq(commit("c2", time.Minute), "mary"))
+		fc.AssertEqual(t, codes.FailedPrecondition, status.Code(err))
+		_, err = drv.Set(ctx, newReq(confirm("c2"), ""))
+		fc.AssertEqual(t, codes.FailedPrecondition, status.Code(err))
+
+		_, err = drv.Set(ctx, newReq(confirm("c1"), ""))
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, barb, data())
+		fc.AssertEqual(t, true, drv.commits.local.pending == nil)
+	})
+
+	t.Run("reconfigure", func(t *testing.T) {
+		drv, data := setup()
+		_, err := drv.Set(ctx, newReq(commit("c1", time.Minute), "barb"))
+		fc.RequireEqual(t, nil, err)
+
+		// server makes a new driver each time it is reconfigured
+		drv = newDriver(drv.device, nil, drv.commits)
+		_, err = drv.Set(ctx, newReq(confirm("c1"), ""))
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, barb, data())
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		drv, data := setup()
+		_, err := drv.Set(ctx, newReq(commit("c1", time.Minute), "barb"))
+		fc.RequireEqual(t, nil, err)
+		_, err = drv.Set(ctx, newReq(cancel("c1"), ""))
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, joe, data())
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		drv, data := setup()
+		_, err := drv.Set(ctx, newReq(commit("c1", time.Millisecond), "barb"))
+		fc.RequireEqual(t, nil, err)
+		time.Sleep(50 * time.Millisecond)
+		drv.commits.local.mu.Lock()
+		fc.AssertEqual(t, joe, data())
+		drv.commits.local.mu.Unlock()
+		_, err = drv.Set(ctx, newReq(confirm("c1"), ""))
+		fc.AssertEqual(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
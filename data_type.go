@@ -0,0 +1,118 @@
+package gnmi
+
+import (
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// dataTypeFilter limits data to config or state according to the type of
+// data requested in a Get
+//
+//	CONFIG      - config true data
+//	STATE       - config false data
+//	OPERATIONAL - config false data except state that reports config as
+//	              applied.  That follows OpenConfig convention where leaves in a
+//	              "state" container repeat leaves in a sibling "config"
+//	              container.
+type dataTypeFilter pb_gnmi.GetRequest_DataType
+
+// apply returns copy of selection that only reads requested type of data
+func (f dataTypeFilter) apply(sel *node.Selection) *node.Selection {
+	if pb_gnmi.GetRequest_DataType(f) == pb_gnmi.GetRequest_ALL {
+		return sel
+	}
+	copy := *sel
+	copy.Constraints = node.NewConstraints(sel.Constraints)
+	copy.Constraints.AddConstraint("gnmi-type", 10, 70, f)
+	return &copy
+}
+
+// includes is true if data for definition belongs in requested type of data.
+// Containers and lists are only included when requested type of data can be
+// under them so they do not show up empty.
+func (f dataTypeFilter) includes(m meta.Definition) bool {
+	config := isConfig(m)
+	switch pb_gnmi.GetRequest_DataType(f) {
+	case pb_gnmi.GetRequest_CONFIG:
+		return config
+	case pb_gnmi.GetRequest_STATE:
+		if meta.IsLeaf(m) {
+			return !config
+		}
+		return !config || f.includesAny(m)
+	case pb_gnmi.GetRequest_OPERATIONAL:
+		if meta.IsLeaf(m) {
+			return !config && !isDerivedState(m)
+		}
+		return f.includesAny(m)
+	}
+	return true
+}
+
+// includesAny is true if any leaf under definition is included. Module root
+// is always included.
+func (f dataTypeFilter) includesAny(m meta.Definition) bool {
+	if _, isModule := m.(*meta.Module); isModule {
+		return true
+	}
+	for _, def := range childDefs(m) {
+		if meta.IsLeaf(def) {
+			if f.includes(def) {
+				return true
+			}
+		} else if f.includesAny(def) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f dataTypeFilter) CheckContainerPreConstraints(r *node.ChildRequest) (bool, error) {
+	if r.IsNavigation() {
+		return true, nil
+	}
+	return f.includes(r.Meta), nil
+}
+
+func (f dataTypeFilter) CheckFieldPreConstraints(r *node.FieldRequest, hnd *node.ValueHandle) (bool, error) {
+	if r.IsNavigation() {
+		return true, nil
+	}
+	return f.includes(r.Meta), nil
+}
+
+func isConfig(m meta.Definition) bool {
+	if c, valid := m.(meta.HasConfig); valid {
+		return c.Config()
+	}
+	// modules
+	return true
+}
+
+// isAllConfig is true if there is no state data in definition or under it
+func isAllConfig(m meta.Definition) bool {
+	if !isConfig(m) {
+		return false
+	}
+	for _, def := range childDefs(m) {
+		if !isAllConfig(def) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDerivedState follows OpenConfig convention where a "state" container
+// repeats leaves of its sibling "config" container to report config as applied
+func isDerivedState(m meta.Definition) bool {
+	state, valid := m.Parent().(*meta.Container)
+	if !valid || state.Ident() != "state" {
+		return false
+	}
+	config, valid := meta.Find(state.Parent(), "config").(*meta.Container)
+	if !valid {
+		return false
+	}
+	return config.Definition(m.Ident()) != nil
+}
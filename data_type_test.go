@@ -0,0 +1,85 @@
+package gnmi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/parser"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestGetDataType(t *testing.T) {
+	mstr := `module y {
+		container iface {
+			container config {
+				leaf mtu {
+					type int32;
+				}
+			}
+			container state {
+				config false;
+				leaf mtu {
+					type int32;
+				}
+				leaf packets {
+					type int64;
+				}
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+	data := map[string]interface{}{
+		"iface": map[string]interface{}{
+			"config": map[string]interface{}{"mtu": 1500},
+			"state":  map[string]interface{}{"mtu": 1500, "packets": 10},
+		},
+	}
+	d := device.New(nil)
+	d.AddBrowser(node.NewBrowser(m, nodeutil.ReflectChild(data)))
+	tests := []struct {
+		dataType pb_gnmi.GetRequest_DataType
+		path     *pb_gnmi.Path
+		expected string
+	}{
+		{
+			dataType: pb_gnmi.GetRequest_ALL,
+			expected: `{"iface":{"config":{"mtu":1500},"state":{"mtu":1500,"packets":10}}}`,
+		},
+		{
+			dataType: pb_gnmi.GetRequest_CONFIG,
+			expected: `{"iface":{"config":{"mtu":1500}}}`,
+		},
+		{
+			dataType: pb_gnmi.GetRequest_STATE,
+			expected: `{"iface":{"state":{"mtu":1500,"packets":10}}}`,
+		},
+		{
+			dataType: pb_gnmi.GetRequest_OPERATIONAL,
+			expected: `{"iface":{"state":{"packets":10}}}`,
+		},
+		{
+			dataType: pb_gnmi.GetRequest_CONFIG,
+			path:     &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "iface"}, {Name: "state"}, {Name: "packets"}}},
+			expected: ``,
+		},
+	}
+	for _, test := range tests {
+		req := &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "y"}},
+			Path:      []*pb_gnmi.Path{test.path},
+			Type:      test.dataType,
+		}
+		resp, err := get(d, context.TODO(), req)
+		fc.RequireEqual(t, nil, err)
+		var actual string
+		if updates := resp.Notification[0].Update; len(updates) > 0 {
+			actual = string(updates[0].Val.GetJsonVal())
+		}
+		fc.AssertEqual(t, test.expected, actual, test.dataType.String())
+	}
+}
@@ -2,8 +2,11 @@ package gnmi
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
 )
 
@@ -11,47 +14,118 @@ import (
 driver bridges between gnmi and FreeCONF. mapping GNMI commands to node operations
 */
 type driver struct {
-	device device.Device
-	subs   *subService
+	device  device.Device
+	subs    *subService
+	commits *commitMgrs
+
+	// when set, requests w/a target are routed to that target's device
+	gateway *gateway
+
 	pb_gnmi.UnimplementedGNMIServer
 }
 
-func newDriver(d device.Device) *driver {
+func newDriver(d device.Device, g *gateway, c *commitMgrs) *driver {
 	return &driver{
-		device: d,
-		subs:   &subService{},
+		device:  d,
+		subs:    &subService{},
+		commits: c,
+		gateway: g,
 	}
 }
 
+// resolve is device for target. Requests w/o a target or when not a gateway
+// go to driver's own device
+func (d *driver) resolve(target string) (device.Device, error) {
+	var devices Devices
+	if d.gateway != nil {
+		devices = d.gateway.get()
+	}
+	if target == "" || devices == nil {
+		return d.device, nil
+	}
+	found, err := devices.Device(target)
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w '%s'", errNoTarget, target)
+	}
+	return found, nil
+}
+
 func (d *driver) Capabilities(ctx context.Context, req *pb_gnmi.CapabilityRequest) (*pb_gnmi.CapabilityResponse, error) {
 	resp := &pb_gnmi.CapabilityResponse{
-		SupportedModels: nil,
-		SupportedEncodings: []pb_gnmi.Encoding{
-			pb_gnmi.Encoding_JSON,
-			pb_gnmi.Encoding_JSON_IETF,
-		},
-		GNMIVersion: Version,
-	}
-	for moduleName, module := range d.device.Modules() {
-		md := &pb_gnmi.ModelData{
-			Name:         moduleName,
-			Organization: module.Organization(),
-			Version:      module.Revision().Ident(),
+		SupportedModels:    nil,
+		SupportedEncodings: supportedEncodings,
+		GNMIVersion:        Version,
+	}
+	// gateway reports every model any of its devices support
+	all := []device.Device{d.device}
+	if d.gateway != nil {
+		if devices := d.gateway.get(); devices != nil {
+			for _, target := range devices.Targets() {
+				// one unreachable device should not hide the rest
+				dev, err := devices.Device(target)
+				if err != nil {
+					fc.Err.Printf("skipping models of target %s. %s", target, err)
+					continue
+				}
+				if dev != nil {
+					all = append(all, dev)
+				}
+			}
+		}
+	}
+	reported := make(map[string]bool)
+	for _, dev := range all {
+		for moduleName, module := range dev.Modules() {
+			md := modelData(moduleName, module)
+			if key := md.Name + "@" + md.Version; !reported[key] {
+				reported[key] = true
+				resp.SupportedModels = append(resp.SupportedModels, md)
+			}
 		}
-		resp.SupportedModels = append(resp.SupportedModels, md)
 	}
 
 	return resp, nil
 }
 
+func modelData(moduleName string, module *meta.Module) *pb_gnmi.ModelData {
+	md := &pb_gnmi.ModelData{
+		Name:         moduleName,
+		Organization: module.Organization(),
+	}
+	// revision is optional in YANG
+	if rev := module.Revision(); rev != nil {
+		md.Version = rev.Ident()
+	}
+	return md
+}
+
 func (d *driver) Set(ctx context.Context, req *pb_gnmi.SetRequest) (*pb_gnmi.SetResponse, error) {
-	return set(d.device, ctx, req)
+	target := req.Prefix.GetTarget()
+	dev, err := d.resolve(target)
+	if err != nil {
+		return nil, statusErr(ctx, err)
+	}
+	var resp *pb_gnmi.SetResponse
+	if isValidateOnly(req) {
+		resp, err = set(newScratchDevice(dev, ctx), ctx, req)
+	} else {
+		resp, err = d.commits.get(target).set(dev, ctx, req)
+	}
+	return resp, statusErr(ctx, err)
 }
 
 func (d *driver) Get(ctx context.Context, req *pb_gnmi.GetRequest) (*pb_gnmi.GetResponse, error) {
-	return get(d.device, ctx, req)
+	dev, err := d.resolve(req.Prefix.GetTarget())
+	if err != nil {
+		return nil, statusErr(ctx, err)
+	}
+	resp, err := get(dev, ctx, req)
+	return resp, statusErr(ctx, err)
 }
 
 func (d *driver) Subscribe(server pb_gnmi.GNMI_SubscribeServer) error {
-	return d.subs.subscribe(d.device, server)
+	return statusErr(server.Context(), d.subs.subscribe(d.resolve, server))
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/freeconf/restconf/device"
@@ -12,6 +14,8 @@ import (
 	"github.com/freeconf/yang/nodeutil"
 	"github.com/freeconf/yang/parser"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var updateFlag = flag.Bool("update", false, "update golden files instead of verifying against them")
@@ -29,7 +33,7 @@ func TestGet(t *testing.T) {
 		},
 	}
 	dev := newTestDevice(data)
-	drv := &driver{device: dev}
+	drv := newDriver(dev, nil, &commitMgrs{})
 	ctx := context.TODO()
 	req := &pb_gnmi.GetRequest{
 		UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
@@ -43,6 +47,115 @@ func TestGet(t *testing.T) {
 	fc.Gold(t, *updateFlag, resp.Notification[0].Update[0].Val.GetJsonVal(), "testdata/get-gold.json")
 }
 
+func TestGetMultiModel(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{"name": "joe"},
+	})
+	addPetModule(dev, map[string]interface{}{
+		"pet": map[string]interface{}{"kind": "cat"},
+	})
+	drv := newDriver(dev, nil, &commitMgrs{})
+	ctx := context.TODO()
+	summary := func(resp *pb_gnmi.GetResponse) string {
+		var actual []string
+		for _, n := range resp.Notification {
+			for _, u := range n.Update {
+				actual = append(actual, n.Prefix.Origin+":"+string(u.Val.GetJsonVal()))
+			}
+		}
+		return strings.Join(actual, " ")
+	}
+
+	t.Run("models", func(t *testing.T) {
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "x"}, {Name: "pets"}},
+			Path:      []*pb_gnmi.Path{{}},
+		})
+		fc.RequireEqual(t, nil, err)
+		fc.AssertEqual(t, 2, len(resp.Notification))
+		fc.AssertEqual(t, `x:{"me":{"name":"joe"}} pets:{"pet":{"kind":"cat"}}`, summary(resp))
+	})
+
+	t.Run("path in one model", func(t *testing.T) {
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "x"}, {Name: "pets"}},
+			Path:      []*pb_gnmi.Path{{Elem: []*pb_gnmi.PathElem{{Name: "pet"}}}},
+		})
+		fc.RequireEqual(t, nil, err)
+		fc.AssertEqual(t, `pets:{"kind":"cat"}`, summary(resp))
+	})
+
+	t.Run("origins", func(t *testing.T) {
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			Path: []*pb_gnmi.Path{
+				{Origin: "pets", Elem: []*pb_gnmi.PathElem{{Name: "pet"}}},
+				{Origin: "x", Elem: []*pb_gnmi.PathElem{{Name: "me"}}},
+				{Origin: "pets", Elem: []*pb_gnmi.PathElem{{Name: "pet"}, {Name: "kind"}}},
+			},
+		})
+		fc.RequireEqual(t, nil, err)
+		fc.AssertEqual(t, 2, len(resp.Notification))
+		fc.AssertEqual(t, `pets:{"kind":"cat"} pets:"cat" x:{"name":"joe"}`, summary(resp))
+	})
+
+	t.Run("origin mismatch", func(t *testing.T) {
+		_, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Path:   []*pb_gnmi.Path{{Origin: "pets"}},
+		})
+		fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestGetNoOrigin(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{"name": "joe"},
+	})
+	addPetModule(dev, map[string]interface{}{
+		"pet": map[string]interface{}{"kind": "cat"},
+	})
+	drv := newDriver(dev, nil, &commitMgrs{})
+	ctx := context.TODO()
+	get := func(names ...string) (string, error) {
+		p := &pb_gnmi.Path{}
+		for _, name := range names {
+			p.Elem = append(p.Elem, &pb_gnmi.PathElem{Name: name})
+		}
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{Path: []*pb_gnmi.Path{p}})
+		if err != nil {
+			return "", err
+		}
+		n := resp.Notification[0]
+		return n.Prefix.Origin + ":" + string(n.Update[0].Val.GetJsonVal()), nil
+	}
+	actual, err := get("pet", "kind")
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `pets:"cat"`, actual)
+	actual, err = get("x:me", "name")
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `x:"joe"`, actual)
+	_, err = get("pets:me")
+	fc.AssertEqual(t, codes.NotFound, status.Code(err))
+	actual, err = get("x:me", "x:name")
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `x:"joe"`, actual)
+	_, err = get("x:me", "bogus:name")
+	fc.AssertEqual(t, codes.NotFound, status.Code(err))
+	_, err = get("nope")
+	fc.AssertEqual(t, codes.NotFound, status.Code(err))
+
+	m, err := parser.LoadModuleFromString(nil, `module z { prefix zz; container me { leaf name { type string; } } }`)
+	fc.RequireEqual(t, nil, err)
+	dev.AddBrowser(node.NewBrowser(m, nodeutil.ReflectChild(map[string]interface{}{
+		"me": map[string]interface{}{"name": "mary"},
+	})))
+	_, err = get("me")
+	fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+	actual, err = get("zz:me", "name")
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `z:"mary"`, actual)
+}
+
 func TestSet(t *testing.T) {
 	me := map[string]interface{}{
 		"name":    "joe",
@@ -57,7 +170,7 @@ func TestSet(t *testing.T) {
 		},
 	}
 	dev := newTestDevice(data)
-	drv := &driver{device: dev}
+	drv := newDriver(dev, nil, &commitMgrs{})
 	ctx := context.TODO()
 	req := &pb_gnmi.SetRequest{
 		Prefix: &pb_gnmi.Path{
@@ -120,6 +233,23 @@ func newTestDevice(initial map[string]interface{}) *device.Local {
 	return d
 }
 
+var petStr = `module pets {
+	container pet {
+		leaf kind {
+			type string;
+		}
+	}
+}`
+
+// addPetModule adds a second module for requests that span modules
+func addPetModule(d *device.Local, initial map[string]interface{}) {
+	m, err := parser.LoadModuleFromString(nil, petStr)
+	if err != nil {
+		panic(err)
+	}
+	d.AddBrowser(node.NewBrowser(m, nodeutil.ReflectChild(initial)))
+}
+
 type editTestOp struct {
 	path *pb_gnmi.Path
 	data string
@@ -171,7 +301,7 @@ func TestUpdate(t *testing.T) {
 			},
 		}
 		dev := newTestDevice(data)
-		drv := &driver{device: dev}
+		drv := newDriver(dev, nil, &commitMgrs{})
 		ctx := context.TODO()
 		req := &pb_gnmi.SetRequest{
 			Prefix: &pb_gnmi.Path{Origin: "x"},
@@ -208,3 +338,157 @@ func TestUpdate(t *testing.T) {
 		fc.Gold(t, *updateFlag, []byte(actual), fmt.Sprintf("testdata/set-%s-gold.json", test.name))
 	}
 }
+
+func TestMissingPaths(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"users": []map[string]interface{}{
+			{"name": "mary"},
+		},
+	})
+	drv := newDriver(dev, nil, &commitMgrs{})
+	ctx := context.TODO()
+	x, _ := dev.Browser("x")
+	path := func(elems ...*pb_gnmi.PathElem) *pb_gnmi.Path {
+		return &pb_gnmi.Path{Origin: "x", Elem: elems}
+	}
+	me := &pb_gnmi.PathElem{Name: "me"}
+	mary := &pb_gnmi.PathElem{Name: "users", Key: map[string]string{"name": "mary"}}
+	sue := &pb_gnmi.PathElem{Name: "users", Key: map[string]string{"name": "sue"}}
+	skill := &pb_gnmi.PathElem{Name: "skill"}
+	jsonVal := func(s string) *pb_gnmi.TypedValue {
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(s)}}
+	}
+
+	t.Run("get", func(t *testing.T) {
+		for _, p := range []*pb_gnmi.Path{path(me), path(sue), path(mary, skill)} {
+			_, err := drv.Get(ctx, &pb_gnmi.GetRequest{Path: []*pb_gnmi.Path{p}})
+			fc.AssertEqual(t, codes.NotFound, status.Code(err))
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		_, err := drv.Set(ctx, &pb_gnmi.SetRequest{
+			Update: []*pb_gnmi.Update{
+				{Path: path(me), Val: jsonVal(`{"name":"joe"}`)},
+				{Path: path(sue, skill), Val: jsonVal(`"welder"`)},
+				{Path: path(mary, skill), Val: jsonVal(`"mechanic"`)},
+			},
+		})
+		fc.RequireEqual(t, nil, err)
+		actual, err := nodeutil.WriteJSON(x.Root())
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, `{"me":{"name":"joe"},"users":[{"name":"mary","skill":"mechanic"},{"name":"sue","skill":"welder"}]}`, actual)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_, err := drv.Set(ctx, &pb_gnmi.SetRequest{
+			Delete: []*pb_gnmi.Path{path(mary, skill), path(sue), path(sue)},
+		})
+		fc.RequireEqual(t, nil, err)
+		actual, err := nodeutil.WriteJSON(x.Root())
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, `{"me":{"name":"joe"},"users":[{"name":"mary"}]}`, actual)
+	})
+}
+
+// unreachable adds a target whose device cannot be reached
+type unreachable struct {
+	*DeviceMap
+}
+
+func (u unreachable) Targets() []string {
+	return append(u.DeviceMap.Targets(), "down")
+}
+
+func (u unreachable) Device(target string) (device.Device, error) {
+	if target == "down" {
+		return nil, fmt.Errorf("%s is down", target)
+	}
+	return u.DeviceMap.Device(target)
+}
+
+func TestGateway(t *testing.T) {
+	local := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{"name": "local"},
+	})
+	a := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{"name": "a"},
+	})
+	b := device.New(nil)
+	addPetModule(b, map[string]interface{}{
+		"pet": map[string]interface{}{"kind": "cat"},
+	})
+	devices := NewDeviceMap()
+	devices.Add("a", a)
+	devices.Add("b", b)
+	drv := newDriver(local, &gateway{devices: devices}, &commitMgrs{})
+	ctx := context.TODO()
+
+	get := func(target string, p *pb_gnmi.Path) (string, error) {
+		resp, err := drv.Get(ctx, &pb_gnmi.GetRequest{
+			Prefix: &pb_gnmi.Path{Target: target},
+			Path:   []*pb_gnmi.Path{p},
+		})
+		if err != nil {
+			return "", err
+		}
+		n := resp.Notification[0]
+		fc.AssertEqual(t, target, n.Prefix.Target)
+		return string(n.Update[0].Val.GetJsonVal()), nil
+	}
+	name := &pb_gnmi.Path{Origin: "x", Elem: []*pb_gnmi.PathElem{{Name: "me"}, {Name: "name"}}}
+	actual, err := get("", name)
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `"local"`, actual)
+	actual, err = get("a", name)
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `"a"`, actual)
+	_, err = get("b", name)
+	fc.AssertEqual(t, codes.NotFound, status.Code(err))
+	_, err = get("c", name)
+	fc.AssertEqual(t, codes.NotFound, status.Code(err))
+
+	_, err = drv.Set(ctx, &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Target: "b", Origin: "pets"},
+		Update: []*pb_gnmi.Update{{
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "pet"}, {Name: "kind"}}},
+			Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "dog"}},
+		}},
+	})
+	fc.AssertEqual(t, nil, err)
+	actual, err = get("b", &pb_gnmi.Path{Origin: "pets", Elem: []*pb_gnmi.PathElem{{Name: "pet"}, {Name: "kind"}}})
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `"dog"`, actual)
+
+	caps, err := drv.Capabilities(ctx, &pb_gnmi.CapabilityRequest{})
+	fc.AssertEqual(t, nil, err)
+	var models []string
+	for _, m := range caps.SupportedModels {
+		models = append(models, m.Name)
+	}
+	sort.Strings(models)
+	fc.AssertEqual(t, "pets,x", strings.Join(models, ","))
+
+	// targets that cannot be reached are left out
+	drv.gateway.set(unreachable{devices})
+	caps, err = drv.Capabilities(ctx, &pb_gnmi.CapabilityRequest{})
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, 2, len(caps.SupportedModels))
+	drv.gateway.set(devices)
+
+	stream := &testSubStream{
+		ctx: context.Background(),
+		reqs: []*pb_gnmi.SubscribeRequest{{
+			Request: &pb_gnmi.SubscribeRequest_Subscribe{
+				Subscribe: &pb_gnmi.SubscriptionList{
+					Mode:         pb_gnmi.SubscriptionList_ONCE,
+					Prefix:       &pb_gnmi.Path{Target: "a", Origin: "x"},
+					Subscription: []*pb_gnmi.Subscription{{Path: name}},
+				},
+			},
+		}},
+	}
+	fc.AssertEqual(t, nil, drv.Subscribe(stream))
+	fc.AssertEqual(t, "update,sync", stream.summary())
+	fc.AssertEqual(t, `"a"`, string(stream.resps[0].GetUpdate().Update[0].Val.GetJsonVal()))
+}
@@ -0,0 +1,72 @@
+package gnmi
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+var validateOnlyMsg = []byte("validate-only")
+
+// ValidateOnly is extension clients add to a SetRequest to find out if it would
+// succeed. Edits are made against a copy of the config so nothing is changed.
+// Only what YANG defines is checked: types, mandatory, unique and must
+// statements. Checks a device's own nodes make as data is written are not run.
+var ValidateOnly = &gnmi_ext.Extension{
+	Ext: &gnmi_ext.Extension_RegisteredExt{
+		RegisteredExt: &gnmi_ext.RegisteredExtension{
+			Id:  gnmi_ext.ExtensionID_EID_EXPERIMENTAL,
+			Msg: validateOnlyMsg,
+		},
+	},
+}
+
+func isValidateOnly(req *pb_gnmi.SetRequest) bool {
+	for _, ext := range req.Extension {
+		r := ext.GetRegisteredExt()
+		if r.GetId() == gnmi_ext.ExtensionID_EID_EXPERIMENTAL && bytes.Equal(r.GetMsg(), validateOnlyMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+// scratchDevice has copies of another device's config made as each module is
+// first used so edits never reach the original device. State data is not
+// copied as it cannot be edited or referenced from config.
+type scratchDevice struct {
+	device.Device
+	ctx      context.Context
+	browsers map[string]*node.Browser
+}
+
+func newScratchDevice(d device.Device, ctx context.Context) *scratchDevice {
+	return &scratchDevice{
+		Device:   d,
+		ctx:      ctx,
+		browsers: make(map[string]*node.Browser),
+	}
+}
+
+func (d *scratchDevice) Browser(module string) (*node.Browser, error) {
+	if b, found := d.browsers[module]; found {
+		return b, nil
+	}
+	live, err := d.Device.Browser(module)
+	if err != nil || live == nil {
+		return live, err
+	}
+	data := make(map[string]interface{})
+	config := dataTypeFilter(pb_gnmi.GetRequest_CONFIG).apply(live.RootWithContext(d.ctx))
+	if err = config.UpsertInto(nodeutil.ReflectChild(data)); err != nil {
+		return nil, err
+	}
+	b := node.NewBrowser(live.Meta, nodeutil.ReflectChild(data))
+	d.browsers[module] = b
+	return b, nil
+}
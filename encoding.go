@@ -0,0 +1,95 @@
+package gnmi
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// supportedEncodings are advertised in capabilities. PROTO sends each leaf as
+// a separate update w/scalar value
+var supportedEncodings = []pb_gnmi.Encoding{
+	pb_gnmi.Encoding_JSON,
+	pb_gnmi.Encoding_JSON_IETF,
+	pb_gnmi.Encoding_PROTO,
+}
+
+func checkEncoding(enc pb_gnmi.Encoding) error {
+	for _, supported := range supportedEncodings {
+		if enc == supported {
+			return nil
+		}
+	}
+	return status.Errorf(codes.Unimplemented, "%s encoding is not supported", enc)
+}
+
+// jsonTypedValue wraps JSON in value for JSON or JSON_IETF encoding
+func jsonTypedValue(data []byte, enc pb_gnmi.Encoding) *pb_gnmi.TypedValue {
+	if enc == pb_gnmi.Encoding_JSON_IETF {
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonIetfVal{JsonIetfVal: data}}
+	}
+	return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: data}}
+}
+
+// leafJSON is value of a single leaf as JSON. Strings and enumerations are
+// quoted, numbers and booleans are not.
+func leafJSON(v val.Value) ([]byte, error) {
+	if l, isList := v.(val.Listable); isList && v.Format().IsList() {
+		items := make([]json.RawMessage, l.Len())
+		for i := range items {
+			item, err := leafJSON(l.Item(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return json.Marshal(items)
+	}
+	switch v.Format() {
+	case val.FmtBool, val.FmtDecimal64,
+		val.FmtInt8, val.FmtInt16, val.FmtInt32, val.FmtInt64,
+		val.FmtUInt8, val.FmtUInt16, val.FmtUInt32, val.FmtUInt64:
+		return json.Marshal(v.Value())
+	}
+	return json.Marshal(v.String())
+}
+
+// scalarData is the Go value of a scalar TypedValue for FreeCONF to convert
+// according to the leaf's YANG type.  False if value is not a scalar.
+func scalarData(v *pb_gnmi.TypedValue) (interface{}, bool) {
+	switch x := v.Value.(type) {
+	case *pb_gnmi.TypedValue_StringVal:
+		return x.StringVal, true
+	case *pb_gnmi.TypedValue_AsciiVal:
+		return x.AsciiVal, true
+	case *pb_gnmi.TypedValue_IntVal:
+		return x.IntVal, true
+	case *pb_gnmi.TypedValue_UintVal:
+		return x.UintVal, true
+	case *pb_gnmi.TypedValue_BoolVal:
+		return x.BoolVal, true
+	case *pb_gnmi.TypedValue_DoubleVal:
+		return x.DoubleVal, true
+	case *pb_gnmi.TypedValue_FloatVal:
+		return float64(x.FloatVal), true
+	case *pb_gnmi.TypedValue_DecimalVal:
+		return float64(x.DecimalVal.Digits) / math.Pow10(int(x.DecimalVal.Precision)), true
+	case *pb_gnmi.TypedValue_BytesVal:
+		return x.BytesVal, true
+	case *pb_gnmi.TypedValue_LeaflistVal:
+		items := make([]interface{}, len(x.LeaflistVal.Element))
+		for i, elem := range x.LeaflistVal.Element {
+			item, valid := scalarData(elem)
+			if !valid {
+				return nil, false
+			}
+			items[i] = item
+		}
+		return items, true
+	}
+	return nil, false
+}
@@ -0,0 +1,66 @@
+package gnmi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLeafJSON(t *testing.T) {
+	tests := []struct {
+		v        val.Value
+		expected string
+	}{
+		{v: val.String("joe"), expected: `"joe"`},
+		{v: val.Int32(10), expected: `10`},
+		{v: val.UInt64(10), expected: `10`},
+		{v: val.Bool(true), expected: `true`},
+		{v: val.Decimal64(1.5), expected: `1.5`},
+		{v: val.Enum{Id: 1, Label: "welder"}, expected: `"welder"`},
+		{v: val.StringList{"a", "b"}, expected: `["a","b"]`},
+	}
+	for _, test := range tests {
+		actual, err := leafJSON(test.v)
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, test.expected, string(actual))
+	}
+}
+
+func TestGetEncoding(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":  "joe",
+			"skill": "manager",
+		},
+	})
+	fetch := func(enc pb_gnmi.Encoding) (*pb_gnmi.Notification, error) {
+		req := &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
+			Path:      []*pb_gnmi.Path{{Elem: []*pb_gnmi.PathElem{{Name: "me"}}}},
+			Encoding:  enc,
+		}
+		resp, err := get(dev, context.TODO(), req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Notification[0], nil
+	}
+
+	n, err := fetch(pb_gnmi.Encoding_JSON_IETF)
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, `{"name":"joe","skill":"manager"}`, string(n.Update[0].Val.GetJsonIetfVal()))
+
+	n, err = fetch(pb_gnmi.Encoding_PROTO)
+	fc.RequireEqual(t, nil, err)
+	fc.RequireEqual(t, 2, len(n.Update))
+	fc.AssertEqual(t, "/me/name", pathString(n.Update[0].Path))
+	fc.AssertEqual(t, "joe", n.Update[0].Val.GetStringVal())
+
+	_, err = fetch(pb_gnmi.Encoding_ASCII)
+	fc.AssertEqual(t, codes.Unimplemented, status.Code(err))
+}
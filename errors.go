@@ -0,0 +1,179 @@
+package gnmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/node"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// errCodes are gRPC codes for errors that are not already a gRPC status
+var errCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{errModelOrOrigin, codes.InvalidArgument},
+	{errNoSelection, codes.InvalidArgument},
+	{errKeysWhenNoList, codes.InvalidArgument},
+	{errNoSampleInterval, codes.InvalidArgument},
+	{errOriginMismatch, codes.InvalidArgument},
+	{errAmbiguousPath, codes.InvalidArgument},
+	{errTypeNotSupported, codes.Unimplemented},
+	{errNoModule, codes.NotFound},
+	{errNoTarget, codes.NotFound},
+	{errPollBeforeSubscribe, codes.FailedPrecondition},
+	{errBadCredentials, codes.Unauthenticated},
+	{fc.NotFoundError, codes.NotFound},
+	{fc.BadRequestError, codes.InvalidArgument},
+	{fc.NotImplementedError, codes.Unimplemented},
+	{fc.ConflictError, codes.AlreadyExists},
+	// Unauthenticated when caller has no identity, see statusErr
+	{fc.UnauthorizedError, codes.PermissionDenied},
+}
+
+// statusErr translates any error into a gRPC status error so clients can tell
+// one kind of failure from another.  Code comes from first gRPC status in the
+// chain, otherwise from errCodes, and details include the path and Set
+// operation when known.  Unauthorized callers w/o an identity in context are
+// unauthenticated as they were never told who they are.
+func statusErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	code := errCode(err)
+	if code == codes.PermissionDenied && errors.Is(err, fc.UnauthorizedError) {
+		if _, known := IdentityFromContext(ctx); !known {
+			code = codes.Unauthenticated
+		}
+	}
+	return newStatus(code, err).Err()
+}
+
+func grpcStatus(err error) *status.Status {
+	return newStatus(errCode(err), err)
+}
+
+func newStatus(code codes.Code, err error) *status.Status {
+	s := status.New(code, errMessage(err))
+	var details []protoiface.MessageV1
+	var pe *pathErr
+	if errors.As(err, &pe) {
+		details = append(details, pe.path)
+	}
+	var oe *opErr
+	if errors.As(err, &oe) {
+		details = append(details, &errdetails.ErrorInfo{
+			Reason: "SET_OPERATION_FAILED",
+			Domain: "gnmi",
+			Metadata: map[string]string{
+				"operation": opName(oe.op),
+				"index":     strconv.Itoa(oe.index),
+			},
+		})
+	}
+	if len(details) == 0 {
+		return s
+	}
+	withDetails, detailsErr := s.WithDetails(details...)
+	if detailsErr != nil {
+		return s
+	}
+	return withDetails
+}
+
+func errCode(err error) codes.Code {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch x := e.(type) {
+		case *pathErr, *opErr:
+			continue
+		case interface{ GRPCStatus() *status.Status }:
+			return x.GRPCStatus().Code()
+		}
+	}
+	// errors are not always comparable so they cannot be map keys
+	for _, x := range errCodes {
+		if errors.Is(err, x.err) {
+			return x.code
+		}
+	}
+	return codes.Unknown
+}
+
+// writeErr is error writing data at selection.  FreeCONF's range, length and
+// pattern checks return errors w/o a code so those are taken as the data being
+// invalid.
+func writeErr(sel *node.Selection, err error) error {
+	if err == nil || errCode(err) != codes.Unknown {
+		return err
+	}
+	return status.Errorf(codes.InvalidArgument, "%s. %s", sel.Path, err)
+}
+
+// errMessage is error w/o the "rpc error: code = ..." that gRPC status errors
+// start with
+func errMessage(err error) string {
+	switch x := err.(type) {
+	case *pathErr, *opErr:
+		return x.Error()
+	case interface{ GRPCStatus() *status.Status }:
+		return x.GRPCStatus().Message()
+	}
+	return err.Error()
+}
+
+// pathErr is an error for a particular gNMI path
+type pathErr struct {
+	path *pb_gnmi.Path
+	err  error
+}
+
+func withPath(err error, path *pb_gnmi.Path) error {
+	if err == nil || path == nil {
+		return err
+	}
+	return &pathErr{path: path, err: err}
+}
+
+func (e *pathErr) Error() string {
+	return errMessage(e.err)
+}
+
+func (e *pathErr) Unwrap() error {
+	return e.err
+}
+
+func (e *pathErr) GRPCStatus() *status.Status {
+	return grpcStatus(e)
+}
+
+// opErr is error from one operation in a SetRequest
+type opErr struct {
+	op    pb_gnmi.UpdateResult_Operation
+	index int
+	err   error
+}
+
+func (e *opErr) Error() string {
+	return fmt.Sprintf("%s[%d] failed. %s", opName(e.op), e.index, errMessage(e.err))
+}
+
+func (e *opErr) Unwrap() error {
+	return e.err
+}
+
+func (e *opErr) GRPCStatus() *status.Status {
+	return grpcStatus(e)
+}
+
+func opName(op pb_gnmi.UpdateResult_Operation) string {
+	return strings.ToLower(op.String())
+}
@@ -0,0 +1,89 @@
+package gnmi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/freeconf/yang/fc"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStatusErr(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+		msg  string
+	}{
+		{err: errModelOrOrigin, code: codes.InvalidArgument},
+		{err: fmt.Errorf("%w 'q'", errNoModule), code: codes.NotFound},
+		{err: fmt.Errorf("%w. x not found", fc.NotFoundError), code: codes.NotFound},
+		{err: status.Error(codes.PermissionDenied, "no"), code: codes.PermissionDenied, msg: "no"},
+		{err: withPath(status.Error(codes.PermissionDenied, "no"), &pb_gnmi.Path{}), code: codes.PermissionDenied, msg: "no"},
+		{err: fmt.Errorf("boom"), code: codes.Unknown},
+		{err: multiErr{errNoModule}, code: codes.Unknown},
+		{err: fmt.Errorf("%w", multiErr{errNoModule}), code: codes.Unknown},
+	}
+	ctx := context.Background()
+	for _, test := range tests {
+		s := status.Convert(statusErr(ctx, test.err))
+		fc.AssertEqual(t, test.code, s.Code(), test.err.Error())
+		if test.msg != "" {
+			fc.AssertEqual(t, test.msg, s.Message())
+		}
+	}
+	fc.AssertEqual(t, nil, statusErr(ctx, nil))
+
+	// unauthorized depends on whether caller said who they are
+	unauthorized := fmt.Errorf("%w. admin only", fc.UnauthorizedError)
+	fc.AssertEqual(t, codes.Unauthenticated, status.Code(statusErr(ctx, unauthorized)))
+	joe := WithIdentity(ctx, &Identity{Username: "joe"})
+	fc.AssertEqual(t, codes.PermissionDenied, status.Code(statusErr(joe, unauthorized)))
+	fc.AssertEqual(t, codes.PermissionDenied, status.Code(statusErr(ctx, status.Error(codes.PermissionDenied, "no"))))
+}
+
+// multiErr cannot be compared w/== like some errors in the wild
+type multiErr []error
+
+func (e multiErr) Error() string {
+	return fmt.Sprint([]error(e))
+}
+
+func TestErrDetails(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{"name": "joe"},
+		"users": []map[string]interface{}{
+			{"name": "mary"},
+		},
+	})
+	drv := newDriver(dev, nil, &commitMgrs{})
+	ctx := context.TODO()
+
+	bad := &pb_gnmi.Path{Origin: "nope", Elem: []*pb_gnmi.PathElem{{Name: "me"}}}
+	_, err := drv.Get(ctx, &pb_gnmi.GetRequest{Path: []*pb_gnmi.Path{bad}})
+	s := status.Convert(err)
+	fc.AssertEqual(t, codes.NotFound, s.Code())
+	fc.RequireEqual(t, 1, len(s.Details()))
+	fc.AssertEqual(t, true, proto.Equal(bad, s.Details()[0].(*pb_gnmi.Path)))
+
+	keyed := &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me", Key: map[string]string{"name": "x"}}}}
+	_, err = drv.Set(ctx, &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Origin: "x"},
+		Delete: []*pb_gnmi.Path{
+			{Elem: []*pb_gnmi.PathElem{{Name: "users"}}},
+			keyed,
+		},
+	})
+	s = status.Convert(err)
+	fc.AssertEqual(t, codes.InvalidArgument, s.Code())
+	fc.AssertEqual(t, "delete[1] failed. found keys when model is not a list", s.Message())
+	fc.RequireEqual(t, 2, len(s.Details()))
+	fc.AssertEqual(t, true, proto.Equal(keyed, s.Details()[0].(*pb_gnmi.Path)))
+	info := s.Details()[1].(*errdetails.ErrorInfo)
+	fc.AssertEqual(t, "delete", info.Metadata["operation"])
+	fc.AssertEqual(t, "1", info.Metadata["index"])
+}
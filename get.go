@@ -2,6 +2,7 @@ package gnmi
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/freeconf/restconf/device"
@@ -13,62 +14,143 @@ import (
 )
 
 func get(d device.Device, ctx context.Context, req *pb_gnmi.GetRequest) (*pb_gnmi.GetResponse, error) {
-	now := time.Now().UnixNano()
-	resp := &pb_gnmi.Notification{
-		Timestamp: now,
-	}
-
-	prefix, err := selectPath(d, req.UseModels, req.Prefix)
-	if err != nil {
+	if err := checkEncoding(req.Encoding); err != nil {
 		return nil, err
 	}
 
+	resp := newNotifications(time.Now().UnixNano(), req.Prefix)
+	filter := dataTypeFilter(req.Type)
 	for _, p := range req.Path {
-		sel, err := advanceSelection(d, prefix, p)
+		prefixes, err := selectPrefixes(d, ctx, req.UseModels, req.Prefix, p)
 		if err != nil {
-			return nil, err
+			return nil, withPath(err, p)
 		}
-		fc.Debug.Printf("get request %s", sel.Path)
-		if sel != nil {
-			val, err := getVal(sel)
+		found := false
+		for _, prefix := range prefixes {
+			matches, err := selectAll(d, ctx, prefix, p)
 			if err != nil {
-				return nil, err
+				return nil, withPath(err, p)
 			}
-			resp.Update = append(resp.Update, &pb_gnmi.Update{
-				Path: p,
-				Val:  val,
-			})
+			found = found || len(matches) > 0
+			updates, err := getMatches(matches, filter, req.Encoding)
+			if err != nil {
+				return nil, withPath(err, p)
+			}
+			n := resp.notification(prefix)
+			n.Update = append(n.Update, updates...)
+		}
+		// no matches for a wildcard is just an empty result
+		if !found && !hasWildcards(p) {
+			return nil, withPath(fmt.Errorf("%w. %s", fc.NotFoundError, pathString(p)), p)
 		}
 	}
 
 	return &pb_gnmi.GetResponse{
-		Notification: []*pb_gnmi.Notification{resp},
+		Notification: resp.list(),
 	}, nil
 }
 
-func getVal(sel *node.Selection) (*pb_gnmi.TypedValue, error) {
-	var v *pb_gnmi.TypedValue
-	if meta.IsLeaf(sel.Path.Meta) {
-		val, err := sel.Get()
+func getMatches(matches []pathMatch, filter dataTypeFilter, enc pb_gnmi.Encoding) ([]*pb_gnmi.Update, error) {
+	var updates []*pb_gnmi.Update
+	for _, m := range matches {
+		fc.Debug.Printf("get request %s", m.sel.Path)
+		if !filter.includes(m.sel.Meta()) {
+			continue
+		}
+		if err := checkAccess(m.sel, PermRead, true); err != nil {
+			return nil, err
+		}
+		sel := filter.apply(m.sel)
+		if enc == pb_gnmi.Encoding_PROTO {
+			leaves, err := getLeaves(sel, basePath(m.path))
+			if err != nil {
+				return nil, err
+			}
+			updates = append(updates, leaves...)
+			continue
+		}
+		val, err := getVal(sel, enc)
 		if err != nil {
 			return nil, err
 		}
-		v = &pb_gnmi.TypedValue{
-			Value: &pb_gnmi.TypedValue_JsonVal{
-				JsonVal: []byte(val.String()),
-			},
+		updates = append(updates, &pb_gnmi.Update{
+			Path: m.path,
+			Val:  val,
+		})
+	}
+	return updates, nil
+}
+
+// notifications groups updates into one notification per origin in the order
+// origins are first used
+type notifications struct {
+	timestamp int64
+	prefix    *pb_gnmi.Path
+	byOrigin  map[string]*pb_gnmi.Notification
+	ordered   []*pb_gnmi.Notification
+}
+
+func newNotifications(timestamp int64, prefix *pb_gnmi.Path) *notifications {
+	return &notifications{
+		timestamp: timestamp,
+		prefix:    prefix,
+		byOrigin:  make(map[string]*pb_gnmi.Notification),
+	}
+}
+
+func (n *notifications) notification(sel *node.Selection) *pb_gnmi.Notification {
+	origin := sel.Browser.Meta.Ident()
+	if x, found := n.byOrigin[origin]; found {
+		return x
+	}
+	x := &pb_gnmi.Notification{
+		Timestamp: n.timestamp,
+		Prefix:    notificationPrefix(origin, n.prefix),
+	}
+	n.byOrigin[origin] = x
+	n.ordered = append(n.ordered, x)
+	return x
+}
+
+func (n *notifications) list() []*pb_gnmi.Notification {
+	if len(n.ordered) == 0 {
+		return []*pb_gnmi.Notification{{Timestamp: n.timestamp}}
+	}
+	return n.ordered
+}
+
+// notificationPrefix is request prefix w/origin so clients can tell which
+// module updates are from
+func notificationPrefix(origin string, prefix *pb_gnmi.Path) *pb_gnmi.Path {
+	return &pb_gnmi.Path{
+		Origin: origin,
+		Target: prefix.GetTarget(),
+		Elem:   prefix.GetElem(),
+	}
+}
+
+func getVal(sel *node.Selection, enc pb_gnmi.Encoding) (*pb_gnmi.TypedValue, error) {
+	if enc == pb_gnmi.Encoding_JSON_IETF {
+		data, err := ietfJSON(sel)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		msg, err := nodeutil.WriteJSON(sel)
+		return jsonTypedValue(data, enc), nil
+	}
+	if meta.IsLeaf(sel.Path.Meta) {
+		v, err := sel.Get()
 		if err != nil {
 			return nil, err
 		}
-		v = &pb_gnmi.TypedValue{
-			Value: &pb_gnmi.TypedValue_JsonVal{
-				JsonVal: []byte(msg),
-			},
+		data, err := leafJSON(v)
+		if err != nil {
+			return nil, err
 		}
+		return jsonTypedValue(data, enc), nil
 	}
-
-	return v, nil
+	msg, err := nodeutil.WriteJSON(sel)
+	if err != nil {
+		return nil, err
+	}
+	return jsonTypedValue([]byte(msg), enc), nil
 }
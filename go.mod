@@ -1,12 +1,15 @@
 module github.com/freeconf/gnmi
 
-go 1.20
+go 1.21
 
 require (
 	github.com/freeconf/restconf v0.0.0-20240126143528-7e8989aa69af
 	github.com/freeconf/yang v0.0.0-20240126135339-ef92ddeb9f99
-	github.com/openconfig/gnmi v0.9.1
+	github.com/openconfig/gnmi v0.11.0
+	golang.org/x/crypto v0.7.0
+	google.golang.org/genproto v0.0.0-20230323212658-478b75c54725
 	google.golang.org/grpc v1.54.0
+	google.golang.org/protobuf v1.31.0
 )
 
 require (
@@ -14,6 +17,4 @@ require (
 	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
-	google.golang.org/genproto v0.0.0-20230323212658-478b75c54725 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -0,0 +1,272 @@
+package gnmi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/val"
+)
+
+// ietfJSON is data at selection encoded according to RFC 7951. FreeCONF's
+// JSON writer is close but does not quote 64-bit numbers, does not always
+// qualify identities and qualifies names from groupings w/wrong module.
+//
+//	https://datatracker.ietf.org/doc/html/rfc7951
+func ietfJSON(sel *node.Selection) ([]byte, error) {
+	if meta.IsLeaf(sel.Path.Meta) {
+		v, err := sel.Get()
+		if err != nil {
+			return nil, err
+		}
+		return ietfValue(sel.Path.Meta.(meta.Leafable), v)
+	}
+	obj := &ietfObject{}
+	var n node.Node
+	if meta.IsList(sel.Path.Meta) && !sel.InsideList {
+		l := &ietfList{}
+		var parent meta.Definition
+		if sel.Path.Parent != nil {
+			parent = sel.Path.Parent.Meta
+		}
+		obj.add(ietfName(sel.Path.Meta, parent), l)
+		n = ietfListNode(l)
+	} else {
+		n = ietfContainerNode(obj)
+	}
+	if err := sel.UpsertInto(n); err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+// ietfUnqualified is decoded RFC 7951 data for a definition w/module names
+// removed from member names once they are checked against the module each
+// member belongs to.  FreeCONF's JSON reader expects names from a grouping
+// qualified w/the grouping's module and not the module RFC 7951 uses.  Data
+// may be wrapped in an object named after the definition itself.
+func ietfUnqualified(data interface{}, m meta.Meta) (interface{}, error) {
+	switch x := data.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			name := localName(k)
+			var def meta.Definition
+			if defs, valid := m.(meta.HasDataDefinitions); valid {
+				def = meta.Find(defs, name)
+			}
+			if self, valid := m.(meta.Definition); valid && def == nil && self.Ident() == name {
+				def = self
+			}
+			if def != nil {
+				if ns := ietfNamespace(def); !qualifies(ns, k) {
+					return nil, fmt.Errorf("%w. %s is not in module %s", fc.BadRequestError, k, ns.Ident())
+				}
+			}
+			var err error
+			if obj[name], err = ietfUnqualified(v, def); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case []interface{}:
+		items := make([]interface{}, len(x))
+		for i, v := range x {
+			var err error
+			if items[i], err = ietfUnqualified(v, m); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return data, nil
+}
+
+// ietfObject keeps members in the order they are written which follows the
+// order in YANG
+type ietfObject struct {
+	members []ietfMember
+}
+
+type ietfMember struct {
+	name  string
+	value json.Marshaler
+}
+
+func (o *ietfObject) add(name string, v json.Marshaler) {
+	o.members = append(o.members, ietfMember{name: name, value: v})
+}
+
+func (o *ietfObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteRune('{')
+	for i, m := range o.members {
+		if i > 0 {
+			buf.WriteRune(',')
+		}
+		name, _ := json.Marshal(m.name)
+		buf.Write(name)
+		buf.WriteRune(':')
+		data, err := m.value.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	buf.WriteRune('}')
+	return buf.Bytes(), nil
+}
+
+type ietfList struct {
+	items []*ietfObject
+}
+
+func (l *ietfList) MarshalJSON() ([]byte, error) {
+	if l.items == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l.items)
+}
+
+func ietfContainerNode(obj *ietfObject) node.Node {
+	return &nodeutil.Basic{
+		OnChild: func(r node.ChildRequest) (node.Node, error) {
+			if !r.New {
+				return nil, nil
+			}
+			name := ietfName(r.Meta, r.Selection.Path.Meta)
+			if meta.IsList(r.Meta) {
+				l := &ietfList{}
+				obj.add(name, l)
+				return ietfListNode(l), nil
+			}
+			child := &ietfObject{}
+			obj.add(name, child)
+			return ietfContainerNode(child), nil
+		},
+		OnField: func(r node.FieldRequest, hnd *node.ValueHandle) error {
+			if !r.Write || hnd.Val == nil {
+				return nil
+			}
+			data, err := ietfValue(r.Meta, hnd.Val)
+			if err != nil {
+				return err
+			}
+			obj.add(ietfName(r.Meta, r.Selection.Path.Meta), json.RawMessage(data))
+			return nil
+		},
+	}
+}
+
+func ietfListNode(l *ietfList) node.Node {
+	return &nodeutil.Basic{
+		OnNext: func(r node.ListRequest) (node.Node, []val.Value, error) {
+			if !r.New {
+				return nil, nil, nil
+			}
+			item := &ietfObject{}
+			l.items = append(l.items, item)
+			return ietfContainerNode(item), r.Key, nil
+		},
+	}
+}
+
+// ietfName qualifies name w/module name when definition is top-level or in a
+// different namespace than its parent, for example an augment.
+func ietfName(m meta.Definition, parent meta.Definition) string {
+	ns := ietfNamespace(m)
+	if _, topLevel := parent.(*meta.Module); !topLevel && parent != nil {
+		if ietfNamespace(parent) == ns {
+			return m.Ident()
+		}
+	}
+	return ns.Ident() + ":" + m.Ident()
+}
+
+// ietfNamespace is module definition's data belongs to.  Definitions from a
+// grouping belong to module w/the uses (RFC 7950 section 7.13) so only
+// top-level and augmented definitions start a new namespace.
+func ietfNamespace(m meta.Definition) *meta.Module {
+	parent := dataParent(m)
+	if parent == nil {
+		return meta.RootModule(m)
+	}
+	ns := ietfNamespace(parent)
+	if orig := meta.OriginalModule(m); orig != ns && isAugmented(orig, m, parent) {
+		return orig
+	}
+	return ns
+}
+
+// dataParent is nearest parent that is data, nil for top-level definitions
+func dataParent(m meta.Definition) meta.Definition {
+	for p := m.Parent(); p != nil; p = p.Parent() {
+		switch x := p.(type) {
+		case *meta.Module:
+			return nil
+		case *meta.Choice, *meta.ChoiceCase:
+			continue
+		case meta.Definition:
+			return x
+		}
+	}
+	return nil
+}
+
+// isAugmented is true if module has an augment that adds definition to parent
+func isAugmented(mod *meta.Module, m meta.Definition, parent meta.Definition) bool {
+	for _, a := range mod.Augments() {
+		target := a.Ident()
+		if i := strings.LastIndexByte(target, '/'); i >= 0 {
+			target = target[i+1:]
+		}
+		if localName(target) == parent.Ident() && a.DataDefinition(m.Ident()) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ietfValue is a leaf value according to RFC 7951 section 6
+func ietfValue(m meta.Leafable, v val.Value) ([]byte, error) {
+	if l, isList := v.(val.Listable); isList && v.Format().IsList() {
+		items := make([]json.RawMessage, l.Len())
+		for i := range items {
+			item, err := ietfValue(m, l.Item(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return json.Marshal(items)
+	}
+	switch v.Format() {
+	case val.FmtInt64, val.FmtUInt64:
+		return json.Marshal(v.String())
+	case val.FmtDecimal64:
+		return json.Marshal(strconv.FormatFloat(v.Value().(float64), 'f', -1, 64))
+	case val.FmtBool, val.FmtInt8, val.FmtInt16, val.FmtInt32,
+		val.FmtUInt8, val.FmtUInt16, val.FmtUInt32:
+		return json.Marshal(v.Value())
+	case val.FmtEmpty:
+		return []byte("[null]"), nil
+	case val.FmtBinary:
+		// FreeCONF keeps binary values base64 encoded already
+		return json.Marshal(v.String())
+	case val.FmtIdentityRef:
+		idty := meta.FindIdentity(m.Type().Base(), v.String())
+		if idty == nil {
+			return nil, fmt.Errorf("could not find identity '%s'", v.String())
+		}
+		return json.Marshal(meta.RootModule(idty).Ident() + ":" + idty.Ident())
+	case val.FmtAny:
+		return json.Marshal(v.Value())
+	}
+	return json.Marshal(v.String())
+}
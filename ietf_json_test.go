@@ -0,0 +1,151 @@
+package gnmi
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/parser"
+	"github.com/freeconf/yang/source"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIetfJSON(t *testing.T) {
+	types := `module t {
+		prefix t;
+		namespace t;
+		identity animal;
+		identity dog {
+			base animal;
+		}
+	}`
+	ext := `module e {
+		prefix e;
+		namespace e;
+		import y {
+			prefix y;
+		}
+		uses y:pets;
+		augment /pet {
+			leaf tag {
+				type string;
+			}
+		}
+	}`
+	mstr := `module y {
+		prefix y;
+		namespace y;
+		import t {
+			prefix t;
+		}
+		grouping pets {
+		container pet {
+			leaf kind {
+				type identityref {
+					base t:animal;
+				}
+			}
+			leaf weight {
+				type decimal64 {
+					fraction-digits 2;
+				}
+			}
+			leaf id {
+				type int64;
+			}
+			leaf age {
+				type int32;
+			}
+			leaf photo {
+				type binary;
+			}
+			list toy {
+				key name;
+				leaf name {
+					type string;
+				}
+			}
+		}
+		}
+	}`
+	ypath := source.Any(
+		source.Named("t", strings.NewReader(types)),
+		source.Named("y", strings.NewReader(mstr)),
+	)
+	m, err := parser.LoadModuleFromString(ypath, ext)
+	fc.RequireEqual(t, nil, err)
+	data := map[string]interface{}{}
+	d := device.New(nil)
+	b := node.NewBrowser(m, nodeutil.ReflectChild(data))
+	d.AddBrowser(b)
+
+	cfg := `{"e:pet":{"kind":"t:dog","weight":"1.5","id":"12","age":3,"toy":[{"name":"ball"}],"tag":"x"}}`
+	req := &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Origin: "e"},
+		Update: []*pb_gnmi.Update{{
+			Path: &pb_gnmi.Path{},
+			Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(cfg)}},
+		}},
+	}
+	_, err = set(d, context.TODO(), req)
+	fc.RequireEqual(t, nil, err)
+
+	actual, err := ietfJSON(b.Root())
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, cfg, string(actual))
+
+	// pet and everything in it is in module w/the uses, not the grouping
+	for _, bad := range []string{`{"y:pet":{"tag":"y"}}`, `{"e:pet":{"y:tag":"y"}}`, `{"e:pet":{"toy":[{"t:name":"y"}]}}`} {
+		req.Update[0].Val = &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonIetfVal{JsonIetfVal: []byte(bad)}}
+		_, err = set(d, context.TODO(), req)
+		fc.AssertEqual(t, codes.InvalidArgument, status.Code(err), bad)
+	}
+	actual, err = ietfJSON(b.Root())
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, cfg, string(actual))
+
+	photo, err := ietfValue(nil, val.Binary("aGk="))
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `"aGk="`, string(photo))
+}
+
+func TestIetfName(t *testing.T) {
+	astr := `module a {
+		prefix a;
+		namespace a;
+		grouping g {
+			leaf x {
+				type string;
+			}
+		}
+	}`
+	bstr := `module b {
+		prefix b;
+		namespace b;
+		import a {
+			prefix a;
+		}
+		container d {
+			uses a:g;
+		}
+	}`
+	ypath := source.Any(
+		source.Named("a", strings.NewReader(astr)),
+		source.Named("b", strings.NewReader(bstr)),
+	)
+	b, err := parser.LoadModule(ypath, "b")
+	fc.RequireEqual(t, nil, err)
+	d := meta.Find(b, "d")
+	fc.AssertEqual(t, "b:d", ietfName(d, b))
+	// from a grouping in module a but namespace is module w/uses
+	fc.AssertEqual(t, "x", ietfName(meta.Find(d.(meta.HasDataDefinitions), "x"), d))
+	fc.AssertEqual(t, "b", ietfNamespace(meta.Find(d.(meta.HasDataDefinitions), "x")).Ident())
+}
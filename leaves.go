@@ -0,0 +1,234 @@
+package gnmi
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// getLeaves flattens data at selection into an update per leaf with full gNMI
+// paths under base and scalar values.  This is what time-series collectors
+// expect when using PROTO encoding instead of JSON blobs.
+func getLeaves(sel *node.Selection, base *pb_gnmi.Path) ([]*pb_gnmi.Update, error) {
+	c, err := collectLeaves(sel, base)
+	if err != nil {
+		return nil, err
+	}
+	return c.updates, nil
+}
+
+func collectLeaves(sel *node.Selection, base *pb_gnmi.Path) (*leafCollector, error) {
+	c := &leafCollector{
+		origin: base.GetOrigin(),
+		paths:  make(map[string]*pb_gnmi.Path),
+	}
+	if meta.IsLeaf(sel.Path.Meta) {
+		v, err := sel.Get()
+		if err != nil || v == nil {
+			return c, err
+		}
+		c.add(base.GetElem(), scalarValue(sel.Path.Meta.(meta.Leafable), v))
+		return c, nil
+	}
+	var n node.Node
+	if meta.IsList(sel.Path.Meta) && !sel.InsideList {
+		// each list item's path element replaces the list's own element
+		ident := sel.Path.Meta.Ident()
+		elems := base.GetElem()
+		if l := len(elems); l > 0 && localName(elems[l-1].GetName()) == ident {
+			elems = elems[:l-1]
+		}
+		n = c.list(elems, ident)
+	} else {
+		n = c.container(base.GetElem())
+	}
+	if err := sel.UpsertInto(n); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// leafCollector is a write-only node that records each leaf written into it
+// as well as the path of every container, list item and leaf
+type leafCollector struct {
+	origin  string
+	updates []*pb_gnmi.Update
+	paths   map[string]*pb_gnmi.Path
+}
+
+func (c *leafCollector) addPath(elems []*pb_gnmi.PathElem) *pb_gnmi.Path {
+	p := &pb_gnmi.Path{Origin: c.origin, Elem: elems}
+	c.paths[pathString(p)] = p
+	return p
+}
+
+func (c *leafCollector) add(elems []*pb_gnmi.PathElem, v *pb_gnmi.TypedValue) {
+	c.updates = append(c.updates, &pb_gnmi.Update{
+		Path: c.addPath(elems),
+		Val:  v,
+	})
+}
+
+func (c *leafCollector) container(elems []*pb_gnmi.PathElem) node.Node {
+	if len(elems) > 0 {
+		c.addPath(elems)
+	}
+	return &nodeutil.Basic{
+		OnChild: func(r node.ChildRequest) (node.Node, error) {
+			if !r.New {
+				return nil, nil
+			}
+			if meta.IsList(r.Meta) {
+				return c.list(elems, r.Meta.Ident()), nil
+			}
+			return c.container(appendElem(elems, &pb_gnmi.PathElem{Name: r.Meta.Ident()})), nil
+		},
+		OnField: func(r node.FieldRequest, hnd *node.ValueHandle) error {
+			if r.Write && hnd.Val != nil {
+				c.add(appendElem(elems, &pb_gnmi.PathElem{Name: r.Meta.Ident()}), scalarValue(r.Meta, hnd.Val))
+			}
+			return nil
+		},
+	}
+}
+
+func (c *leafCollector) list(elems []*pb_gnmi.PathElem, ident string) node.Node {
+	return &nodeutil.Basic{
+		OnNext: func(r node.ListRequest) (node.Node, []val.Value, error) {
+			if !r.New {
+				return nil, nil, nil
+			}
+			elem := &pb_gnmi.PathElem{Name: ident}
+			if len(r.Key) > 0 {
+				elem.Key = make(map[string]string, len(r.Key))
+				for i, k := range r.Meta.KeyMeta() {
+					elem.Key[k.Ident()] = r.Key[i].String()
+				}
+			}
+			return c.container(appendElem(elems, elem)), r.Key, nil
+		},
+	}
+}
+
+// appendElem never alters elems as sibling paths share them
+func appendElem(elems []*pb_gnmi.PathElem, elem *pb_gnmi.PathElem) []*pb_gnmi.PathElem {
+	return append(append(make([]*pb_gnmi.PathElem, 0, len(elems)+1), elems...), elem)
+}
+
+// scalarValue converts FreeCONF value of leaf to gNMI scalar value
+func scalarValue(m meta.Leafable, v val.Value) *pb_gnmi.TypedValue {
+	if l, isList := v.(val.Listable); isList && v.Format().IsList() {
+		arr := &pb_gnmi.ScalarArray{}
+		for i := 0; i < l.Len(); i++ {
+			arr.Element = append(arr.Element, scalarValue(m, l.Item(i)))
+		}
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_LeaflistVal{LeaflistVal: arr}}
+	}
+	switch v.Format() {
+	case val.FmtBool:
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_BoolVal{BoolVal: v.Value().(bool)}}
+	case val.FmtInt8, val.FmtInt16, val.FmtInt32, val.FmtInt64:
+		if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_IntVal{IntVal: i}}
+		}
+	case val.FmtUInt8, val.FmtUInt16, val.FmtUInt32, val.FmtUInt64:
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_UintVal{UintVal: u}}
+		}
+	case val.FmtDecimal64:
+		precision := fractionDigits(m.Type())
+		digits := math.Round(v.Value().(float64) * math.Pow10(precision))
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_DecimalVal{DecimalVal: &pb_gnmi.Decimal64{
+			Digits:    int64(digits),
+			Precision: uint32(precision),
+		}}}
+	case val.FmtBinary:
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_BytesVal{BytesVal: v.Value().([]byte)}}
+	}
+	return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: v.String()}}
+}
+
+// fractionDigits of decimal64 type or of first decimal64 type in a union
+func fractionDigits(t *meta.Type) int {
+	if d := t.FractionDigits(); d > 0 {
+		return d
+	}
+	for _, u := range t.Union() {
+		if d := fractionDigits(u); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// pathString is a canonical string of a gNMI path useful as a map key
+func pathString(p *pb_gnmi.Path) string {
+	var b strings.Builder
+	b.WriteString(p.GetOrigin())
+	for _, e := range p.GetElem() {
+		b.WriteRune('/')
+		b.WriteString(e.Name)
+		keys := make([]string, 0, len(e.Key))
+		for k := range e.Key {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteRune('[')
+			b.WriteString(k)
+			b.WriteRune('=')
+			b.WriteString(e.Key[k])
+			b.WriteRune(']')
+		}
+	}
+	return b.String()
+}
+
+// isPathStringPrefix is like isPathPrefix but for paths from pathString where
+// list items start w/'[' (e.g. /users[name=mary])
+func isPathStringPrefix(prefix string, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/' || path[len(prefix)] == '['
+}
+
+// removedPaths are paths in previous that are not in current. Only top-most
+// path of each removed subtree is included.
+func removedPaths(previous map[string]*pb_gnmi.Path, current map[string]*pb_gnmi.Path) []*pb_gnmi.Path {
+	var keys []string
+	for k := range previous {
+		if _, found := current[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var removed []*pb_gnmi.Path
+	for _, k := range keys {
+		p := previous[k]
+		if !isParentRemoved(p, previous, current) {
+			removed = append(removed, p)
+		}
+	}
+	return removed
+}
+
+func isParentRemoved(p *pb_gnmi.Path, previous map[string]*pb_gnmi.Path, current map[string]*pb_gnmi.Path) bool {
+	for i := len(p.Elem) - 1; i > 0; i-- {
+		parent := pathString(&pb_gnmi.Path{Origin: p.Origin, Elem: p.Elem[:i]})
+		if _, existed := previous[parent]; !existed {
+			continue
+		}
+		if _, exists := current[parent]; !exists {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package gnmi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/parser"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestGetLeaves(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":  "joe",
+			"skill": "manager",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+		},
+	})
+	b, _ := dev.Browser("x")
+	leaves, err := getLeaves(b.Root(), &pb_gnmi.Path{Origin: "x"})
+	fc.RequireEqual(t, nil, err)
+	var actual []string
+	for _, l := range leaves {
+		actual = append(actual, pathString(l.Path)+"="+l.Val.GetStringVal())
+	}
+	expected := []string{
+		"x/me/name=joe",
+		"x/me/skill=manager",
+		"x/users[name=mary]/name=mary",
+		"x/users[name=mary]/skill=welder",
+	}
+	fc.AssertEqual(t, strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+
+	users, err := b.Root().Find("users")
+	fc.RequireEqual(t, nil, err)
+	leaves, err = getLeaves(users, &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "users"}}})
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, 2, len(leaves))
+	fc.AssertEqual(t, "/users[name=mary]/skill", pathString(leaves[1].Path))
+}
+
+func TestScalarValue(t *testing.T) {
+	mstr := `module x {
+		leaf i {
+			type int32;
+		}
+		leaf u {
+			type uint64;
+		}
+		leaf b {
+			type boolean;
+		}
+		leaf d {
+			type decimal64 {
+				fraction-digits 2;
+			}
+		}
+		leaf e {
+			type enumeration {
+				enum mechanic;
+				enum welder;
+			}
+		}
+		leaf-list s {
+			type string;
+		}
+		leaf-list ds {
+			type union {
+				type int32;
+				type decimal64 {
+					fraction-digits 3;
+				}
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+	leaf := func(ident string) meta.Leafable {
+		return meta.Find(m, ident).(meta.Leafable)
+	}
+	fc.AssertEqual(t, int64(-3), scalarValue(leaf("i"), val.Int32(-3)).GetIntVal())
+	fc.AssertEqual(t, uint64(3), scalarValue(leaf("u"), val.UInt64(3)).GetUintVal())
+	fc.AssertEqual(t, true, scalarValue(leaf("b"), val.Bool(true)).GetBoolVal())
+	dec := scalarValue(leaf("d"), val.Decimal64(1.5)).GetDecimalVal()
+	fc.AssertEqual(t, int64(150), dec.Digits)
+	fc.AssertEqual(t, uint32(2), dec.Precision)
+	fc.AssertEqual(t, "welder", scalarValue(leaf("e"), val.Enum{Id: 1, Label: "welder"}).GetStringVal())
+	list := scalarValue(leaf("s"), val.StringList{"a", "b"}).GetLeaflistVal()
+	fc.AssertEqual(t, 2, len(list.Element))
+	fc.AssertEqual(t, "b", list.Element[1].GetStringVal())
+	decs := scalarValue(leaf("ds"), val.Decimal64List{0.1, 2.25}).GetLeaflistVal()
+	fc.AssertEqual(t, int64(2250), decs.Element[1].GetDecimalVal().Digits)
+	fc.AssertEqual(t, uint32(3), decs.Element[1].GetDecimalVal().Precision)
+}
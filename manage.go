@@ -1,6 +1,10 @@
 package gnmi
 
 import (
+	"context"
+	"crypto/tls"
+
+	"github.com/freeconf/restconf/stock"
 	"github.com/freeconf/yang/fc"
 	"github.com/freeconf/yang/node"
 	"github.com/freeconf/yang/nodeutil"
@@ -13,6 +17,29 @@ func Manage(s *Server) node.Node {
 			switch r.Meta.Ident() {
 			case "web":
 				return options(s), nil
+			case "authentication":
+				if r.Delete {
+					s.SetAuthenticator(nil)
+					return nil, nil
+				}
+				if r.New {
+					s.SetAuthenticator(s.users)
+				}
+				if s.Authenticator() == Authenticator(s.users) {
+					return authNode(s.users), nil
+				}
+			case "authorization":
+				if r.Delete {
+					s.SetAuthorization(nil)
+					return nil, nil
+				}
+				rbac := s.Authorization()
+				if r.New {
+					rbac = &Rbac{}
+				}
+				if rbac != nil {
+					return authzNode(s, rbac.clone()), nil
+				}
 			}
 			return nil, nil
 		},
@@ -32,8 +59,26 @@ func Manage(s *Server) node.Node {
 
 func options(s *Server) node.Node {
 	opts := s.Options()
+	opts.Tls = copyTls(opts.Tls)
 	return &nodeutil.Extend{
 		Base: nodeutil.ReflectChild(&opts),
+		OnChild: func(parent node.Node, r node.ChildRequest) (node.Node, error) {
+			switch r.Meta.Ident() {
+			case "tls":
+				if r.Delete {
+					opts.Tls = nil
+					return nil, nil
+				}
+				if r.New {
+					opts.Tls = &stock.Tls{}
+				}
+				if opts.Tls != nil {
+					return stock.TlsNode(opts.Tls), nil
+				}
+				return nil, nil
+			}
+			return parent.Child(r)
+		},
 		OnEndEdit: func(parent node.Node, r node.NodeRequest) error {
 			if err := parent.EndEdit(r); err != nil {
 				return err
@@ -42,3 +87,116 @@ func options(s *Server) node.Node {
 		},
 	}
 }
+
+// copyTls is copy of TLS options that can be edited w/o changing certificates
+// or CAs of options in use
+func copyTls(src *stock.Tls) *stock.Tls {
+	if src == nil {
+		return nil
+	}
+	copy := &stock.Tls{
+		CertFile: src.CertFile,
+		KeyFile:  src.KeyFile,
+		Config:   *src.Config.Clone(),
+	}
+	if src.Config.Certificates != nil {
+		copy.Config.Certificates = append([]tls.Certificate{}, src.Config.Certificates...)
+	}
+	if src.Config.RootCAs != nil {
+		copy.Config.RootCAs = src.Config.RootCAs.Clone()
+	}
+	return copy
+}
+
+// authzNode edits a copy of policy so policy in use is never partially edited
+func authzNode(s *Server, rbac *Rbac) node.Node {
+	return &nodeutil.Extend{
+		Base: nodeutil.ReflectChild(rbac),
+		OnEndEdit: func(parent node.Node, r node.NodeRequest) error {
+			if err := parent.EndEdit(r); err != nil {
+				return err
+			}
+			s.SetAuthorization(rbac)
+			return nil
+		},
+	}
+}
+
+func authNode(users *UserList) node.Node {
+	return &nodeutil.Basic{
+		OnChild: func(r node.ChildRequest) (node.Node, error) {
+			switch r.Meta.Ident() {
+			case "user":
+				return usersNode(users), nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+func usersNode(users *UserList) node.Node {
+	var names []string
+	return &nodeutil.Basic{
+		OnNext: func(r node.ListRequest) (node.Node, []val.Value, error) {
+			var name string
+			key := r.Key
+			if key != nil {
+				name = key[0].String()
+				if r.New {
+					users.Set(name, "")
+				} else if r.Delete {
+					users.Remove(name)
+					return nil, nil, nil
+				} else if _, found := users.PasswordHash(name); !found {
+					return nil, nil, nil
+				}
+			} else {
+				if names == nil {
+					names = users.Usernames()
+				}
+				if r.Row >= len(names) {
+					return nil, nil, nil
+				}
+				name = names[r.Row]
+				key = []val.Value{val.String(name)}
+			}
+			return userNode(users, name), key, nil
+		},
+	}
+}
+
+func userNode(users *UserList, name string) node.Node {
+	return &nodeutil.Basic{
+		OnField: func(r node.FieldRequest, hnd *node.ValueHandle) error {
+			switch r.Meta.Ident() {
+			case "name":
+				if !r.Write {
+					hnd.Val = val.String(name)
+				}
+			case "passwordHash":
+				if r.Write {
+					users.Set(name, hnd.Val.String())
+				} else if !readsPasswordHashes(r.Selection.Context) {
+					// hashes can be attacked offline so they are never read back
+					return nil
+				} else if hash, _ := users.PasswordHash(name); hash != "" {
+					hnd.Val = val.String(hash)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+type passwordHashesKey struct{}
+
+// withPasswordHashes lets reads that only save config to put it back later see
+// password hashes
+func withPasswordHashes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, passwordHashesKey{}, true)
+}
+
+func readsPasswordHashes(ctx context.Context) bool {
+	reads, _ := ctx.Value(passwordHashesKey{}).(bool)
+	return reads
+}
@@ -1,11 +1,14 @@
 package gnmi
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
 	"github.com/freeconf/yang/meta"
 	"github.com/freeconf/yang/node"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
@@ -13,120 +16,267 @@ import (
 
 var errModelOrOrigin = errors.New("you must use models or use origin as model")
 
-// TBH, i don't understand the use case for multiple models
-var errOnlyOneModel = errors.New("only one model is currently supported")
+var errOriginMismatch = errors.New("path origin does not match prefix origin")
 
 var errNoSelection = errors.New("no prefix or path found")
 
 var errKeysWhenNoList = errors.New("found keys when model is not a list")
 
-func selectPath(device device.Device, models []*pb_gnmi.ModelData, path *pb_gnmi.Path) (*node.Selection, error) {
-	var model string
-	if len(models) > 0 {
-		if len(models) > 1 {
-			return nil, errOnlyOneModel
-		}
-		model = models[0].Name
-	} else if path == nil {
-		return nil, nil
-	} else if path.Origin == "" && len(path.Elem) > 0 {
+var errNoModule = errors.New("no module found with name")
+
+var errAmbiguousPath = errors.New("more than one module has path, use origin or qualify name w/module")
+
+// selectPath is path in module named by path's origin
+func selectPath(device device.Device, ctx context.Context, path *pb_gnmi.Path) (*node.Selection, error) {
+	if path.GetOrigin() == "" {
 		return nil, errModelOrOrigin
-	} else {
-		model = path.Origin
 	}
-	b, err := device.Browser(model)
+	s, err := selectModule(device, ctx, path.Origin)
+	if err != nil || len(path.Elem) == 0 {
+		return s, err
+	}
+	return advanceSelection(device, ctx, s, path)
+}
+
+func selectModule(device device.Device, ctx context.Context, module string) (*node.Selection, error) {
+	b, err := device.Browser(module)
 	if err != nil {
 		return nil, err
 	}
 	if b == nil {
-		return nil, fmt.Errorf("no module with name '%s' found", models[0].Name)
+		return nil, fmt.Errorf("%w '%s'", errNoModule, module)
+	}
+	s := b.RootWithContext(ctx)
+	secureSelection(s)
+	return s, nil
+}
+
+// selectPrefixes is the prefix in each module path applies to.  Origin in path
+// or prefix decides the module otherwise path applies to every model
+// requested.
+func selectPrefixes(device device.Device, ctx context.Context, models []*pb_gnmi.ModelData, prefix *pb_gnmi.Path, path *pb_gnmi.Path) ([]*node.Selection, error) {
+	modules, err := pathModules(device, models, prefix, path)
+	if err != nil {
+		return nil, err
 	}
-	s := b.Root()
-	ptr := s
-	if path != nil && len(path.Elem) > 0 {
-		s, err = advanceSelection(device, ptr, path)
+	var sels []*node.Selection
+	for _, module := range modules {
+		sel, err := selectPath(device, ctx, &pb_gnmi.Path{Origin: module, Elem: prefix.GetElem()})
 		if err != nil {
 			return nil, err
 		}
-		ptr = s
+		// path only has to be in one of several models
+		if len(modules) > 1 && !definesPath(sel, path) {
+			continue
+		}
+		sels = append(sels, sel)
 	}
-	return ptr, nil
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("%w. %s not in any model", fc.NotFoundError, pathString(path))
+	}
+	return sels, nil
 }
 
-func advanceSelection(device device.Device, prefix *node.Selection, path *pb_gnmi.Path) (*node.Selection, error) {
-	if prefix == nil && path == nil {
-		return nil, errNoSelection
+// definesPath is false if selection's schema cannot have path under it
+func definesPath(sel *node.Selection, path *pb_gnmi.Path) bool {
+	for _, e := range path.GetElem() {
+		switch e.GetName() {
+		case "":
+			continue
+		case anyElem, anyLevels:
+			return true
+		}
+		return findDef(sel.Meta(), e.Name) != nil
 	}
-	ptr := prefix
-	if prefix == nil {
-		if path == nil {
+	return true
+}
+
+func pathModules(device device.Device, models []*pb_gnmi.ModelData, prefix *pb_gnmi.Path, path *pb_gnmi.Path) ([]string, error) {
+	origin := path.GetOrigin()
+	if prefix.GetOrigin() != "" {
+		if origin != "" && origin != prefix.Origin {
+			return nil, fmt.Errorf("%w. '%s' and '%s'", errOriginMismatch, origin, prefix.Origin)
+		}
+		origin = prefix.Origin
+	}
+	if origin != "" {
+		return []string{origin}, nil
+	}
+	if len(models) > 0 {
+		modules := make([]string, len(models))
+		for i, m := range models {
+			modules[i] = m.Name
+		}
+		return modules, nil
+	}
+	first := firstElem(prefix)
+	if first == "" {
+		first = firstElem(path)
+	}
+	if first == "" {
+		if prefix == nil && path == nil {
 			return nil, errNoSelection
 		}
-		if path.Origin == "" {
-			return nil, errModelOrOrigin
+		return nil, errModelOrOrigin
+	}
+	return findModules(device, first)
+}
+
+// findModules are modules that have a top-level definition w/name. Names can
+// be qualified w/module name or prefix (e.g. x:me) to pick between modules
+// w/same definition.  Wildcards are in every module.
+func findModules(device device.Device, name string) ([]string, error) {
+	wild := name == anyElem || name == anyLevels
+	var found []string
+	for ident, m := range device.Modules() {
+		if wild || (qualifies(m, name) && meta.Find(m, localName(name)) != nil) {
+			found = append(found, ident)
 		}
-		var err error
-		if ptr, err = selectPath(device, nil, path); err != nil {
-			return nil, err
+	}
+	sort.Strings(found)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w. no module has '%s'", fc.NotFoundError, name)
+	}
+	if len(found) > 1 && !wild {
+		return nil, fmt.Errorf("%w. '%s' is in %s", errAmbiguousPath, name, strings.Join(found, ", "))
+	}
+	return found, nil
+}
+
+func firstElem(path *pb_gnmi.Path) string {
+	for _, e := range path.GetElem() {
+		if e.GetName() != "" {
+			return e.Name
 		}
-		return ptr, nil
 	}
+	return ""
+}
 
-	if ptr == nil {
-		return nil, errNoSelection
+// localName is element name w/o the module name or prefix it may be
+// qualified with
+func localName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
 	}
+	return name
+}
 
-	if path != nil {
-		for _, seg := range path.Elem {
-			if seg == nil || seg.Name == "" {
-				continue
-			}
-			ident := seg.Name
-			if len(seg.Key) > 0 {
-				lmeta, valid := ptr.Meta().(*meta.List)
-				if !valid {
-					return nil, errKeysWhenNoList
-				}
-				ident = ident + "=" + encodeKey(lmeta, seg.Key)
-			}
-			s, err := ptr.Find(ident) // should find take keys to avoid encode/decoding step?
-			if err != nil || s == nil {
-				return nil, err
+// qualifies is true if name is not qualified or qualified w/module's name or
+// prefix
+func qualifies(m *meta.Module, name string) bool {
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return true
+	}
+	q := name[:i]
+	return q == m.Ident() || q == m.Prefix()
+}
+
+// findDef is definition under parent for a path element name.  Qualified names
+// have to use the module, or its prefix, definition is in
+func findDef(parent meta.Meta, name string) meta.Definition {
+	defs, valid := parent.(meta.HasDataDefinitions)
+	if !valid {
+		return nil
+	}
+	def := meta.Find(defs, localName(name))
+	if def == nil || !qualifies(ietfNamespace(def), name) {
+		return nil
+	}
+	return def
+}
+
+func advanceSelection(device device.Device, ctx context.Context, prefix *node.Selection, path *pb_gnmi.Path) (*node.Selection, error) {
+	sel, missing, err := selectExisting(device, ctx, prefix, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, notFound(sel, missing)
+	}
+	return sel, nil
+}
+
+// selectExisting is like advanceSelection but when path does not exist, it is
+// the nearest selection that does along w/the path elements that do not.
+func selectExisting(device device.Device, ctx context.Context, prefix *node.Selection, path *pb_gnmi.Path) (*node.Selection, []*pb_gnmi.PathElem, error) {
+	if path == nil {
+		if prefix == nil {
+			return nil, nil, errNoSelection
+		}
+		return prefix, nil, nil
+	}
+	ptr := prefix
+	if prefix == nil {
+		if path.Origin == "" {
+			return nil, nil, errModelOrOrigin
+		}
+		var err error
+		if ptr, err = selectModule(device, ctx, path.Origin); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, seg := range path.Elem {
+		if seg == nil || seg.Name == "" {
+			continue
+		}
+		def := findDef(ptr.Meta(), seg.Name)
+		if def == nil {
+			return nil, nil, fmt.Errorf("%w. %s not in %s", fc.NotFoundError, seg.Name, ptr.Path)
+		}
+		ident := def.Ident()
+		if len(seg.Key) > 0 {
+			lmeta, valid := def.(*meta.List)
+			if !valid {
+				return nil, nil, errKeysWhenNoList
 			}
-			ptr = s
+			ident = ident + "=" + encodeKey(lmeta, seg.Key)
+		}
+		s, err := ptr.Find(ident) // should find take keys to avoid encode/decoding step?
+		if err != nil {
+			return nil, nil, err
+		}
+		if s == nil {
+			return ptr, path.Elem[i:], nil
 		}
+		ptr = s
 	}
+	return ptr, nil, nil
+}
 
-	return ptr, nil
+func notFound(sel *node.Selection, missing []*pb_gnmi.PathElem) error {
+	return fmt.Errorf("%w. %s not found in %s", fc.NotFoundError, missing[0].Name, sel.Path)
 }
 
 // Posted on 4/3/23 asking question on openconfig google group about how
 // set is only method that doesn't have a use_model
-func selectFullPath(device device.Device, prefix *pb_gnmi.Path, path *pb_gnmi.Path) (*node.Selection, error) {
-	var ptr *node.Selection
-	var err error
-	if prefix != nil {
-		if ptr, err = selectPath(device, nil, prefix); err != nil {
-			return nil, err
-		}
+func selectFullPath(device device.Device, ctx context.Context, prefix *pb_gnmi.Path, path *pb_gnmi.Path) (*node.Selection, error) {
+	sel, missing, err := selectFullExisting(device, ctx, prefix, path)
+	if err != nil {
+		return nil, err
 	}
-	if path != nil {
-		if ptr == nil {
-			if ptr, err = selectPath(device, nil, path); err != nil {
-				return nil, err
-			}
-		} else {
-			s, err := advanceSelection(device, ptr, path)
-			if err != nil {
-				return nil, err
-			}
-			ptr = s
-		}
+	if len(missing) > 0 {
+		return nil, notFound(sel, missing)
+	}
+	return sel, nil
+}
+
+// selectFullExisting is selectFullPath for paths that may not exist yet
+func selectFullExisting(device device.Device, ctx context.Context, prefix *pb_gnmi.Path, path *pb_gnmi.Path) (*node.Selection, []*pb_gnmi.PathElem, error) {
+	prefixes, err := selectPrefixes(device, ctx, nil, prefix, path)
+	if err != nil {
+		return nil, nil, err
 	}
-	if ptr == nil {
-		return nil, errNoSelection
+	return selectExisting(device, ctx, prefixes[0], path)
+}
+
+// isPathPrefix is true if path is at or under prefix where both are in
+// FreeCONF path format (e.g. x/users=mary/name)
+func isPathPrefix(prefix string, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
 	}
-	return ptr, nil
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
 }
 
 func encodeKey(m *meta.List, keys map[string]string) string {
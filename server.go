@@ -1,18 +1,30 @@
 package gnmi
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
+	"sync"
 
 	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/restconf/stock"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var Version = "0.0.0"
 
 type ServerOpts struct {
 	Port string
+
+	// Tls when set serves gNMI over TLS using the certificate, key and CA
+	// from fc-gnmi web/tls. When nil, gNMI is served in plaintext.
+	Tls *stock.Tls
+
+	// RequireClientCert rejects clients that do not present a certificate
+	// signed by the CA in Tls.  Only applies when Tls is set.
+	RequireClientCert bool
 }
 
 type Server struct {
@@ -21,10 +33,19 @@ type Server struct {
 	listener   net.Listener
 	driver     *driver
 	device     *device.Local
+	users      *UserList
+	authMu     sync.RWMutex
+	auth       Authenticator
+	rbac       *Rbac
+	gateway    gateway
+	commits    commitMgrs
 }
 
 func NewServer(d *device.Local) *Server {
-	s := &Server{device: d}
+	s := &Server{
+		device: d,
+		users:  NewUserList(),
+	}
 
 	if err := d.Add("fc-gnmi", Manage(s)); err != nil {
 		panic(err)
@@ -37,6 +58,56 @@ func (s *Server) Options() ServerOpts {
 	return s.opts
 }
 
+// Authenticator checks gNMI username and password metadata on every call.  Nil
+// means calls are not authenticated.
+func (s *Server) Authenticator() Authenticator {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+	return s.auth
+}
+
+// SetAuthenticator replaces the authenticator.  By default the user list in
+// fc-gnmi authentication is used when that container is configured.
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.auth = auth
+}
+
+// Authorization is role based access policy enforced on every Get, Set and
+// Subscribe. Nil means every caller may access everything.
+func (s *Server) Authorization() *Rbac {
+	s.authMu.RLock()
+	defer s.authMu.RUnlock()
+	return s.rbac
+}
+
+// SetAuthorization replaces the access policy.  Policy should not be altered
+// once set, set a new one instead.
+func (s *Server) SetAuthorization(rbac *Rbac) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.rbac = rbac
+}
+
+// Devices routes requests w/a target in the prefix to other devices making
+// server a gateway. Nil means targets are ignored and all requests go to the
+// server's own device.
+func (s *Server) Devices() Devices {
+	return s.gateway.get()
+}
+
+// SetDevices replaces the devices requests are routed to by target, see
+// DeviceMap.
+func (s *Server) SetDevices(devices Devices) {
+	s.gateway.set(devices)
+}
+
+// Users is the default authenticator's user list
+func (s *Server) Users() *UserList {
+	return s.users
+}
+
 func (s *Server) Apply(opts ServerOpts) error {
 	var err error
 	if s.grpcServer != nil {
@@ -47,8 +118,8 @@ func (s *Server) Apply(opts ServerOpts) error {
 		s.listener.Close()
 		s.listener = nil
 	}
-	s.grpcServer = grpc.NewServer()
-	s.driver = newDriver(s.device)
+	s.grpcServer = grpc.NewServer(s.serverOptions(opts)...)
+	s.driver = newDriver(s.device, &s.gateway, &s.commits)
 	pb_gnmi.RegisterGNMIServer(s.grpcServer, s.driver)
 	s.listener, err = net.Listen("tcp", opts.Port)
 	if err != nil {
@@ -59,6 +130,22 @@ func (s *Server) Apply(opts ServerOpts) error {
 	return nil
 }
 
+func (s *Server) serverOptions(opts ServerOpts) []grpc.ServerOption {
+	grpcOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryIdentity, s.unaryAuth, s.unaryAuthz),
+		grpc.ChainStreamInterceptor(streamIdentity, s.streamAuth, s.streamAuthz),
+	}
+	if opts.Tls != nil {
+		config := opts.Tls.Config.Clone()
+		if opts.RequireClientCert {
+			config.ClientCAs = config.RootCAs
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	return grpcOpts
+}
+
 func (s *Server) start() {
 	go func() {
 		if err := s.grpcServer.Serve(s.listener); err != nil {
@@ -2,8 +2,10 @@ package gnmi
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/freeconf/restconf/device"
@@ -12,56 +14,69 @@ import (
 	"github.com/freeconf/yang/node"
 	"github.com/freeconf/yang/nodeutil"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// set applies all operations or none at all as gNMI requires
 func set(d device.Device, ctx context.Context, req *pb_gnmi.SetRequest) (*pb_gnmi.SetResponse, error) {
-	var updates []*pb_gnmi.UpdateResult
-	// order according to gNMI spec should be delete, replace then update
-	for _, del := range req.Delete {
-		sel, err := selectFullPath(d, req.Prefix, del)
-		if err != nil {
-			return nil, err
+	resp, _, err := transact(d, ctx, req)
+	return resp, err
+}
+
+// transact is set but returns transaction so changes can be rolled back later
+func transact(d device.Device, ctx context.Context, req *pb_gnmi.SetRequest) (*pb_gnmi.SetResponse, *transaction, error) {
+	tx := newTransaction(ctx)
+	// validate-only is asked to find out if edits are valid so guessing is
+	// not good enough
+	tx.strict = isValidateOnly(req)
+	resp, err := applySet(d, ctx, tx, req)
+	if err == nil {
+		err = tx.validate()
+	}
+	if err != nil {
+		if rbErr := tx.rollback(ctx); rbErr != nil {
+			fc.Err.Printf("could not roll back set request. %s", rbErr)
 		}
-		fc.Debug.Printf("del request %s", sel.Path)
-		err = sel.Delete()
-		if err != nil {
-			return nil, err
+		return nil, nil, err
+	}
+	return resp, tx, nil
+}
+
+func applySet(d device.Device, ctx context.Context, tx *transaction, req *pb_gnmi.SetRequest) (*pb_gnmi.SetResponse, error) {
+	var updates []*pb_gnmi.UpdateResult
+	// order according to gNMI spec should be delete, replace, union_replace then
+	// update
+	for i, del := range req.Delete {
+		if err := applyDelete(d, ctx, tx, req.Prefix, del); err != nil {
+			return nil, &opErr{op: pb_gnmi.UpdateResult_DELETE, index: i, err: withPath(err, del)}
 		}
 		updates = append(updates, &pb_gnmi.UpdateResult{
 			Op:   pb_gnmi.UpdateResult_DELETE,
 			Path: del,
 		})
 	}
-	for _, u := range req.Replace {
-		sel, err := selectFullPath(d, req.Prefix, u.Path)
-		if err != nil {
-			return nil, err
-		}
-		fc.Debug.Printf("replace request %s", sel.Path)
-		if sel == nil {
-			return nil, fmt.Errorf("no selection found at %s", u.String())
-		}
-		err = setVal(sel, modeReplace, u.Val)
-		if err != nil {
-			return nil, err
+	for i, u := range req.Replace {
+		if err := applyUpdate(d, ctx, tx, req.Prefix, u, modeReplace); err != nil {
+			return nil, &opErr{op: pb_gnmi.UpdateResult_REPLACE, index: i, err: withPath(err, u.Path)}
 		}
 		updates = append(updates, &pb_gnmi.UpdateResult{
 			Op:   pb_gnmi.UpdateResult_REPLACE,
 			Path: u.Path,
 		})
 	}
-	for _, u := range req.Update {
-		sel, err := selectFullPath(d, req.Prefix, u.Path)
-		if err != nil {
-			return nil, err
-		}
-		fc.Debug.Printf("update request %s", sel.Path)
-		err = setVal(sel, modePatch, u.Val)
-		if err != nil {
-			return nil, err
-		}
-		if sel == nil {
-			return nil, fmt.Errorf("no selection found at %s", u.String())
+	if err := applyUnionReplace(d, ctx, tx, req.Prefix, req.UnionReplace); err != nil {
+		return nil, err
+	}
+	for _, u := range req.UnionReplace {
+		updates = append(updates, &pb_gnmi.UpdateResult{
+			Op:   pb_gnmi.UpdateResult_UNION_REPLACE,
+			Path: u.Path,
+		})
+	}
+	for i, u := range req.Update {
+		if err := applyUpdate(d, ctx, tx, req.Prefix, u, modePatch); err != nil {
+			return nil, &opErr{op: pb_gnmi.UpdateResult_UPDATE, index: i, err: withPath(err, u.Path)}
 		}
 		updates = append(updates, &pb_gnmi.UpdateResult{
 			Op:   pb_gnmi.UpdateResult_UPDATE,
@@ -75,6 +90,223 @@ func set(d device.Device, ctx context.Context, req *pb_gnmi.SetRequest) (*pb_gnm
 	}, nil
 }
 
+// applyDelete removes data at path. Deleting what does not exist is not an
+// error.
+func applyDelete(d device.Device, ctx context.Context, tx *transaction, prefix *pb_gnmi.Path, del *pb_gnmi.Path) error {
+	sel, missing, err := selectFullExisting(d, ctx, prefix, del)
+	if err != nil || len(missing) > 0 {
+		return err
+	}
+	fc.Debug.Printf("del request %s", sel.Path)
+	if err = checkAccess(sel, PermFull, false); err != nil {
+		return err
+	}
+	if err = tx.save(sel, nil); err != nil {
+		return err
+	}
+	return deleteSel(sel)
+}
+
+func applyUpdate(d device.Device, ctx context.Context, tx *transaction, prefix *pb_gnmi.Path, u *pb_gnmi.Update, mode int) error {
+	sel, missing, err := selectFullExisting(d, ctx, prefix, u.Path)
+	if err != nil {
+		return err
+	}
+	return writeVal(tx, sel, missing, mode, u.Val)
+}
+
+// unionOrigin is the union of all union_replace values in one origin
+type unionOrigin struct {
+	root *node.Selection
+	data map[string]interface{}
+
+	// first union_replace in origin, errors replacing origin are reported here
+	index int
+}
+
+// applyUnionReplace replaces config in each origin w/union of all values given
+// for that origin.  Values are merged before anything is replaced so values at
+// overlapping paths (e.g. / and /users) add to each other.
+func applyUnionReplace(d device.Device, ctx context.Context, tx *transaction, prefix *pb_gnmi.Path, updates []*pb_gnmi.Update) error {
+	var origins []*unionOrigin
+	byModule := make(map[string]*unionOrigin)
+	for i, u := range updates {
+		prefixes, err := selectPrefixes(d, ctx, nil, prefix, u.Path)
+		if err != nil {
+			return unionErr(i, u, err)
+		}
+		module := prefixes[0].Browser.Meta
+		elems := make([]*pb_gnmi.PathElem, 0, len(prefix.GetElem())+len(u.Path.GetElem()))
+		elems = append(append(elems, prefix.GetElem()...), u.Path.GetElem()...)
+		data, err := rootData(module, elems, u.Val)
+		if err != nil {
+			return unionErr(i, u, err)
+		}
+		o, found := byModule[module.Ident()]
+		if !found {
+			root, err := selectModule(d, ctx, module.Ident())
+			if err != nil {
+				return unionErr(i, u, err)
+			}
+			o = &unionOrigin{root: root, data: make(map[string]interface{}), index: i}
+			byModule[module.Ident()] = o
+			origins = append(origins, o)
+		}
+		mergeData(module, o.data, data)
+	}
+	for _, o := range origins {
+		if err := replaceOrigin(tx, o.root, o.data); err != nil {
+			return unionErr(o.index, updates[o.index], err)
+		}
+	}
+	return nil
+}
+
+func unionErr(index int, u *pb_gnmi.Update, err error) error {
+	return &opErr{op: pb_gnmi.UpdateResult_UNION_REPLACE, index: index, err: withPath(err, u.Path)}
+}
+
+// rootData is value wrapped in each path element so it is data from module root
+func rootData(module *meta.Module, path []*pb_gnmi.PathElem, v *pb_gnmi.TypedValue) (map[string]interface{}, error) {
+	elems, defs, err := pathDefs(module, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(defs) == 0 {
+		data, err := valueData(v, module)
+		if err != nil {
+			return nil, err
+		}
+		return wrapData(nil, data)
+	}
+	last := defs[len(defs)-1]
+	data, err := valueData(v, last)
+	if err != nil {
+		return nil, err
+	}
+	if _, isList := last.(*meta.List); isList && len(elems[len(elems)-1].Key) == 0 {
+		// whole list, value is items
+		if obj, valid := data.(map[string]interface{}); valid {
+			if items, found := obj[last.Ident()]; found {
+				data = items
+			}
+		}
+	} else {
+		data = unwrapData(data, last)
+	}
+	return wrapData(elems, data)
+}
+
+// mergeData adds data from one value into another. List items w/same keys are
+// merged and otherwise later leaf values win.
+func mergeData(parent meta.HasDataDefinitions, into map[string]interface{}, from map[string]interface{}) {
+	for k, v := range from {
+		existing, found := into[k]
+		def := meta.Find(parent, localName(k))
+		if !found || def == nil {
+			into[k] = v
+			continue
+		}
+		switch x := def.(type) {
+		case *meta.List:
+			items, valid := existing.([]interface{})
+			more, validMore := v.([]interface{})
+			if !valid || !validMore {
+				into[k] = v
+				continue
+			}
+			into[k] = mergeItems(x, items, more)
+		case meta.HasDataDefinitions:
+			obj, valid := existing.(map[string]interface{})
+			more, validMore := v.(map[string]interface{})
+			if !valid || !validMore {
+				into[k] = v
+				continue
+			}
+			mergeData(x, obj, more)
+		default:
+			into[k] = v
+		}
+	}
+}
+
+// mergeItems adds items into list, merging items that have same keys
+func mergeItems(l *meta.List, items []interface{}, more []interface{}) []interface{} {
+	for _, m := range more {
+		item, valid := m.(map[string]interface{})
+		if !valid {
+			items = append(items, m)
+			continue
+		}
+		match := findItem(l, items, item)
+		if match == nil {
+			items = append(items, item)
+			continue
+		}
+		mergeData(l, match, item)
+	}
+	return items
+}
+
+// findItem is item in list w/same keys. Lists w/o keys never match.
+func findItem(l *meta.List, items []interface{}, item map[string]interface{}) map[string]interface{} {
+	keys := l.KeyMeta()
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, candidate := range items {
+		obj, valid := candidate.(map[string]interface{})
+		if !valid {
+			continue
+		}
+		same := true
+		for _, k := range keys {
+			if fmt.Sprint(obj[k.Ident()]) != fmt.Sprint(item[k.Ident()]) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return obj
+		}
+	}
+	return nil
+}
+
+// replaceOrigin replaces all config in module w/data
+func replaceOrigin(tx *transaction, root *node.Selection, data map[string]interface{}) error {
+	fc.Debug.Printf("union replace %s", root.Path)
+	if err := checkAccess(root, PermFull, false); err != nil {
+		return err
+	}
+	if err := tx.save(root, nil); err != nil {
+		return err
+	}
+	n, err := nodeutil.ReadJSONValues(data)
+	if err != nil {
+		return err
+	}
+	return writeErr(root, replaceRoot(root, n))
+}
+
+// writeVal sets value at selection or, when path elements are missing,
+// creates them under selection
+func writeVal(tx *transaction, sel *node.Selection, missing []*pb_gnmi.PathElem, mode int, v *pb_gnmi.TypedValue) error {
+	fc.Debug.Printf("set request %s", sel.Path)
+	// creating only needs access to what is created which access constraint checks
+	partial := mode == modePatch || len(missing) > 0
+	if err := checkAccess(sel, PermFull, partial); err != nil {
+		return err
+	}
+	if err := tx.save(sel, missing); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return writeErr(sel, createVal(sel, missing, mode, v))
+	}
+	return writeErr(sel, setVal(sel, mode, v))
+}
+
 const (
 	modePatch = iota
 	modeReplace
@@ -84,31 +316,40 @@ var errTypeNotSupported = errors.New("gnmi encoding type not supported")
 
 func setVal(sel *node.Selection, mode int, v *pb_gnmi.TypedValue) error {
 	if v == nil {
-		return fmt.Errorf("empty value for %s", sel.Path)
+		return fmt.Errorf("%w. empty value for %s", fc.BadRequestError, sel.Path)
 	}
 	if meta.IsLeaf(sel.Path.Meta) {
-		var vstr string
 		switch x := v.Value.(type) {
 		case *pb_gnmi.TypedValue_JsonIetfVal:
-			vstr = string(x.JsonIetfVal)
+			return setLeafJSON(sel, x.JsonIetfVal)
 		case *pb_gnmi.TypedValue_JsonVal:
-			vstr = string(x.JsonVal)
-		default:
+			return setLeafJSON(sel, x.JsonVal)
+		}
+		data, valid := scalarData(v)
+		if !valid {
 			return errTypeNotSupported
 		}
-		return sel.SetValue(vstr)
+		return setLeaf(sel, data)
 	}
 
-	var data string
+	var n node.Node
+	var err error
 	switch x := v.Value.(type) {
 	case *pb_gnmi.TypedValue_JsonIetfVal:
-		data = string(x.JsonIetfVal)
+		var data map[string]interface{}
+		if err = json.Unmarshal(x.JsonIetfVal, &data); err != nil {
+			return fmt.Errorf("%w. %s", fc.BadRequestError, err)
+		}
+		var unqualified interface{}
+		if unqualified, err = ietfUnqualified(data, sel.Meta()); err != nil {
+			return err
+		}
+		n, err = nodeutil.ReadJSONValues(unqualified.(map[string]interface{}))
 	case *pb_gnmi.TypedValue_JsonVal:
-		data = string(x.JsonVal)
+		n, err = nodeutil.ReadJSON(string(x.JsonVal))
 	default:
 		return errTypeNotSupported
 	}
-	n, err := nodeutil.ReadJSON(data)
 	if err != nil {
 		return err
 	}
@@ -118,9 +359,209 @@ func setVal(sel *node.Selection, mode int, v *pb_gnmi.TypedValue) error {
 			return err
 		}
 	case modeReplace:
+		if _, isRoot := sel.Meta().(*meta.Module); isRoot {
+			return replaceRoot(sel, n)
+		}
 		if err := sel.ReplaceFrom(n); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// replaceRoot replaces all config in a module.  FreeCONF cannot replace a
+// selection w/o a parent.
+func replaceRoot(sel *node.Selection, n node.Node) error {
+	if err := deleteSel(sel); err != nil {
+		return err
+	}
+	return sel.UpsertFrom(n)
+}
+
+// deleteSel is like FreeCONF's Delete but also works on leaves and on root
+// where all config in module is deleted
+func deleteSel(sel *node.Selection) error {
+	if _, isRoot := sel.Meta().(*meta.Module); isRoot {
+		for _, def := range childDefs(sel.Meta()) {
+			if !isConfig(def) {
+				continue
+			}
+			if err := deleteChild(sel, def); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if leaf, isLeaf := sel.Meta().(meta.Leafable); isLeaf {
+		return sel.Parent().ClearField(leaf)
+	}
+	return sel.Delete()
+}
+
+// deleteChild removes container, list or leaf under selection if it exists
+func deleteChild(sel *node.Selection, def meta.Definition) error {
+	if leaf, isLeaf := def.(meta.Leafable); isLeaf {
+		return sel.ClearField(leaf)
+	}
+	child, err := sel.Find(def.Ident())
+	if err != nil || child == nil {
+		return err
+	}
+	return child.Delete()
+}
+
+// setLeafJSON sets leaf from its JSON value. Values that are not valid JSON
+// like unquoted strings are taken as is.  Both JSON and RFC 7951 forms of
+// 64-bit numbers and identities are accepted.
+func setLeafJSON(sel *node.Selection, data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return setLeaf(sel, string(data))
+	}
+	return setLeaf(sel, v)
+}
+
+// setLeaf converts data according to leaf's YANG type
+func setLeaf(sel *node.Selection, data interface{}) error {
+	v, err := node.NewValue(sel.Path.Meta.(meta.Leafable).Type(), data)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s. %s", sel.Path, err)
+	}
+	return sel.Set(v)
+}
+
+// createVal sets value at path elements under selection that do not exist yet
+// by wrapping value in the containers and list items that are missing
+func createVal(sel *node.Selection, missing []*pb_gnmi.PathElem, mode int, v *pb_gnmi.TypedValue) error {
+	elems, defs, err := pathDefs(sel.Meta(), missing)
+	if err != nil {
+		return err
+	}
+	if len(defs) == 0 {
+		return setVal(sel, mode, v)
+	}
+	last := defs[len(defs)-1]
+	data, err := valueData(v, last)
+	if err != nil {
+		return err
+	}
+	if mode == modeReplace {
+		data = unwrapData(data, last)
+	}
+	wrapped, err := wrapData(elems, data)
+	if err != nil {
+		return err
+	}
+	n, err := nodeutil.ReadJSONValues(wrapped)
+	if err != nil {
+		return err
+	}
+	return sel.UpsertFrom(n)
+}
+
+// pathDefs are definitions for each path element under parent.  Every list but
+// the last must have keys to know which item the path goes thru
+func pathDefs(parent meta.Meta, path []*pb_gnmi.PathElem) ([]*pb_gnmi.PathElem, []meta.Definition, error) {
+	var elems []*pb_gnmi.PathElem
+	var defs []meta.Definition
+	for _, seg := range path {
+		if seg == nil || seg.Name == "" {
+			continue
+		}
+		if meta.IsLeaf(parent) {
+			return nil, nil, fmt.Errorf("%w. cannot select inside leaf %s", fc.BadRequestError, parent.(meta.Definition).Ident())
+		}
+		def := findDef(parent, seg.Name)
+		if def == nil {
+			return nil, nil, fmt.Errorf("%w. %s not found", fc.NotFoundError, seg.Name)
+		}
+		_, isList := def.(*meta.List)
+		if len(seg.Key) > 0 && !isList {
+			return nil, nil, errKeysWhenNoList
+		}
+		elems = append(elems, seg)
+		defs = append(defs, def)
+		parent = def
+	}
+	for i := 0; i < len(defs)-1; i++ {
+		if _, isList := defs[i].(*meta.List); isList && len(elems[i].Key) == 0 {
+			return nil, nil, fmt.Errorf("%w. keys required for %s", fc.BadRequestError, defs[i].Ident())
+		}
+	}
+	return elems, defs, nil
+}
+
+// wrapData wraps data in each path element so it can be read from the parent
+// of the first element.  Elements w/keys become a single list item.
+func wrapData(elems []*pb_gnmi.PathElem, data interface{}) (map[string]interface{}, error) {
+	for i := len(elems) - 1; i >= 0; i-- {
+		seg := elems[i]
+		if len(seg.Key) > 0 {
+			item, valid := data.(map[string]interface{})
+			if !valid {
+				return nil, fmt.Errorf("%w. expected object for %s", fc.BadRequestError, seg.Name)
+			}
+			for k, kv := range seg.Key {
+				item[k] = kv
+			}
+			data = []interface{}{item}
+		}
+		data = map[string]interface{}{localName(seg.Name): data}
+	}
+	obj, valid := data.(map[string]interface{})
+	if !valid {
+		return nil, fmt.Errorf("%w. expected object", fc.BadRequestError)
+	}
+	return obj, nil
+}
+
+// valueData is value for definition as Go data as if decoded from JSON. Leaf
+// values that are not valid JSON like unquoted strings are taken as is
+func valueData(v *pb_gnmi.TypedValue, m meta.Meta) (interface{}, error) {
+	if v == nil {
+		return nil, fmt.Errorf("%w. empty value", fc.BadRequestError)
+	}
+	var raw []byte
+	switch x := v.Value.(type) {
+	case *pb_gnmi.TypedValue_JsonIetfVal:
+		raw = x.JsonIetfVal
+	case *pb_gnmi.TypedValue_JsonVal:
+		raw = x.JsonVal
+	default:
+		data, valid := scalarData(v)
+		if !valid {
+			return nil, errTypeNotSupported
+		}
+		return data, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		if meta.IsLeaf(m) {
+			return string(raw), nil
+		}
+		return nil, fmt.Errorf("%w. %s", fc.BadRequestError, err)
+	}
+	if _, isIetf := v.Value.(*pb_gnmi.TypedValue_JsonIetfVal); isIetf {
+		return ietfUnqualified(data, m)
+	}
+	return data, nil
+}
+
+// unwrapData removes the object w/definition's name that replace values are
+// wrapped in
+func unwrapData(data interface{}, def meta.Definition) interface{} {
+	obj, valid := data.(map[string]interface{})
+	if !valid || len(obj) != 1 {
+		return data
+	}
+	for k, v := range obj {
+		if k != def.Ident() && !strings.HasSuffix(k, ":"+def.Ident()) {
+			return data
+		}
+		if items, isList := v.([]interface{}); isList && len(items) == 1 {
+			return items[0]
+		}
+		return v
+	}
+	return data
+}
@@ -0,0 +1,487 @@
+package gnmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/parser"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSetScalar(t *testing.T) {
+	mstr := `module x {
+		prefix x;
+		namespace x;
+		container c {
+			leaf name {
+				type string {
+					pattern "[a-z]+";
+				}
+			}
+			leaf age {
+				type int32 {
+					range "0..150";
+				}
+			}
+			leaf count {
+				type uint64;
+			}
+			leaf active {
+				type boolean;
+			}
+			leaf weight {
+				type decimal64 {
+					fraction-digits 2;
+				}
+			}
+			leaf skill {
+				type enumeration {
+					enum mechanic;
+					enum welder;
+				}
+			}
+			leaf-list tags {
+				type string;
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+	data := map[string]interface{}{
+		"c": map[string]interface{}{},
+	}
+	d := device.New(nil)
+	b := node.NewBrowser(m, nodeutil.ReflectChild(data))
+	d.AddBrowser(b)
+
+	update := func(leaf string, v *pb_gnmi.TypedValue) error {
+		req := &pb_gnmi.SetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Update: []*pb_gnmi.Update{{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "c"}, {Name: leaf}}},
+				Val:  v,
+			}},
+		}
+		_, err := set(d, context.TODO(), req)
+		return err
+	}
+	fc.AssertEqual(t, nil, update("name", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "joe"}}))
+	fc.AssertEqual(t, nil, update("age", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_IntVal{IntVal: 40}}))
+	fc.AssertEqual(t, nil, update("count", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_UintVal{UintVal: 99}}))
+	fc.AssertEqual(t, nil, update("active", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_BoolVal{BoolVal: true}}))
+	fc.AssertEqual(t, nil, update("weight", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_DecimalVal{DecimalVal: &pb_gnmi.Decimal64{Digits: 125, Precision: 2}}}))
+	fc.AssertEqual(t, nil, update("skill", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "welder"}}))
+	tags := &pb_gnmi.ScalarArray{Element: []*pb_gnmi.TypedValue{
+		{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "a"}},
+		{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "b"}},
+	}}
+	fc.AssertEqual(t, nil, update("tags", &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_LeaflistVal{LeaflistVal: tags}}))
+
+	actual, err := nodeutil.WriteJSON(b.Root())
+	fc.AssertEqual(t, nil, err)
+	expected := `{"c":{"name":"joe","age":40,"count":99,"active":true,"weight":1.25,"skill":"welder","tags":["a","b"]}}`
+	fc.AssertEqual(t, expected, actual)
+
+	invalid := []struct {
+		leaf string
+		v    *pb_gnmi.TypedValue
+	}{
+		{leaf: "age", v: &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_IntVal{IntVal: 200}}},
+		{leaf: "name", v: &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "JOE"}}},
+		{leaf: "skill", v: &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "chef"}}},
+		{leaf: "active", v: &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_StringVal{StringVal: "maybe"}}},
+	}
+	for _, test := range invalid {
+		err := update(test.leaf, test.v)
+		fc.AssertEqual(t, codes.InvalidArgument, status.Code(err), test.leaf)
+		fc.AssertEqual(t, true, err != nil && strings.Contains(err.Error(), "c/"+test.leaf), test.leaf)
+	}
+
+	// checks FreeCONF makes writing objects
+	req := &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Origin: "x"},
+		Update: []*pb_gnmi.Update{{
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "c"}}},
+			Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(`{"age":200}`)}},
+		}},
+	}
+	_, err = set(d, context.TODO(), req)
+	fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+	fc.AssertEqual(t, true, err != nil && strings.Contains(err.Error(), "required ranges"), fmt.Sprint(err))
+}
+
+func TestSetRollback(t *testing.T) {
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":  "joe",
+			"skill": "manager",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+		},
+	}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	before, err := nodeutil.WriteJSON(b.Root())
+	fc.RequireEqual(t, nil, err)
+
+	jsonVal := func(s string) *pb_gnmi.TypedValue {
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(s)}}
+	}
+	req := &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Origin: "x"},
+		Delete: []*pb_gnmi.Path{
+			{Elem: []*pb_gnmi.PathElem{{Name: "users"}}},
+		},
+		Update: []*pb_gnmi.Update{
+			{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}, {Name: "name"}}},
+				Val:  jsonVal(`"barb"`),
+			},
+			{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}, {Name: "skill"}}},
+				Val:  jsonVal(`"chef"`),
+			},
+		},
+	}
+	_, err = set(dev, context.TODO(), req)
+	fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+	fc.AssertEqual(t, true, strings.HasPrefix(status.Convert(err).Message(), "update[1] failed."))
+
+	after, err := nodeutil.WriteJSON(b.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, before, after)
+}
+
+func TestSetRollbackPaths(t *testing.T) {
+	jsonVal := func(s string) *pb_gnmi.TypedValue {
+		return &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(s)}}
+	}
+	path := func(elems ...*pb_gnmi.PathElem) *pb_gnmi.Path {
+		return &pb_gnmi.Path{Elem: elems}
+	}
+	me := &pb_gnmi.PathElem{Name: "me"}
+	users := &pb_gnmi.PathElem{Name: "users"}
+	mary := &pb_gnmi.PathElem{Name: "users", Key: map[string]string{"name": "mary"}}
+	sue := &pb_gnmi.PathElem{Name: "users", Key: map[string]string{"name": "sue"}}
+	skill := &pb_gnmi.PathElem{Name: "skill"}
+	address := &pb_gnmi.PathElem{Name: "address"}
+	// last update always fails so everything before it is rolled back
+	fail := &pb_gnmi.Update{Path: path(me, skill), Val: jsonVal(`"chef"`)}
+	tests := []struct {
+		name    string
+		del     []*pb_gnmi.Path
+		replace []*pb_gnmi.Update
+		update  []*pb_gnmi.Update
+	}{
+		{name: "delete list", del: []*pb_gnmi.Path{path(users)}},
+		{name: "delete item", del: []*pb_gnmi.Path{path(mary)}},
+		{name: "delete leaf", del: []*pb_gnmi.Path{path(mary, skill)}},
+		{name: "set leaf", update: []*pb_gnmi.Update{{Path: path(mary, skill), Val: jsonVal(`"mechanic"`)}}},
+		{name: "set empty leaf", update: []*pb_gnmi.Update{{Path: path(mary, address), Val: jsonVal(`"x"`)}}},
+		{name: "create item", update: []*pb_gnmi.Update{{Path: path(sue), Val: jsonVal(`{"skill":"welder"}`)}}},
+		{name: "replace item", replace: []*pb_gnmi.Update{{Path: path(mary), Val: jsonVal(`{"users":[{"name":"mary"}]}`)}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meData := map[string]interface{}{"name": "joe", "skill": "manager"}
+			data := map[string]interface{}{
+				"me": meData,
+				"users": []map[string]interface{}{
+					{"name": "mary", "skill": "welder"},
+				},
+			}
+			dev := newTestDevice(data)
+			b, _ := dev.Browser("x")
+			before, err := nodeutil.WriteJSON(b.Root())
+			fc.RequireEqual(t, nil, err)
+			req := &pb_gnmi.SetRequest{
+				Prefix:  &pb_gnmi.Path{Origin: "x"},
+				Delete:  test.del,
+				Replace: test.replace,
+				Update:  append(test.update, fail),
+			}
+			_, err = set(dev, context.TODO(), req)
+			fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+			after, err := nodeutil.WriteJSON(b.Root())
+			fc.AssertEqual(t, nil, err)
+			fc.AssertEqual(t, before, after)
+			// data that was not touched is left alone
+			meData["skill"] = "x"
+			fc.AssertEqual(t, "x", data["me"].(map[string]interface{})["skill"])
+		})
+	}
+}
+
+func TestSetRollbackContinues(t *testing.T) {
+	me := map[string]interface{}{"name": "joe", "skill": "manager"}
+	dev := newTestDevice(map[string]interface{}{"me": me})
+	b, _ := dev.Browser("x")
+	before, err := nodeutil.WriteJSON(b.Root())
+	fc.RequireEqual(t, nil, err)
+	tx := newTransaction(context.TODO())
+	save := func(path string) {
+		sel, err := b.Root().Find(path)
+		fc.RequireEqual(t, nil, err)
+		fc.RequireEqual(t, nil, tx.save(sel, nil))
+	}
+	save("me/name")
+	// item is gone by the time of rollback so this restore fails
+	users := meta.Find(b.Meta, "users").(meta.HasDataDefinitions)
+	tx.undos = append(tx.undos, &undo{
+		browser: b,
+		path:    "users=mary/skill",
+		leaf:    meta.Find(users, "skill").(meta.Leafable),
+		value:   val.Enum{Id: 1, Label: "welder"},
+	})
+	save("me/skill")
+	me["name"] = "barb"
+	me["skill"] = "welder"
+
+	err = tx.rollback(context.TODO())
+	fc.AssertEqual(t, true, errors.Is(err, fc.NotFoundError))
+	after, err := nodeutil.WriteJSON(b.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, before, after)
+}
+
+func TestSetValidateOnly(t *testing.T) {
+	mstr := `module x {
+		prefix x;
+		namespace x;
+		container c {
+			must "max > 0" {
+				error-message "max must be more than zero";
+			}
+			leaf name {
+				type string;
+				mandatory true;
+			}
+			leaf min {
+				type int32;
+			}
+			leaf max {
+				type int32;
+			}
+			list server {
+				key id;
+				unique "host port";
+				leaf id {
+					type int32;
+				}
+				leaf host {
+					type string;
+				}
+				leaf port {
+					type int32;
+				}
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+	data := map[string]interface{}{
+		"c": map[string]interface{}{
+			"name": "joe",
+			"min":  1,
+			"max":  10,
+			"server": []interface{}{
+				map[string]interface{}{"id": 1, "host": "a", "port": 80},
+				map[string]interface{}{"id": 2, "host": "b", "port": 80},
+			},
+		},
+	}
+	d := device.New(nil)
+	b := node.NewBrowser(m, nodeutil.ReflectChild(data))
+	d.AddBrowser(b)
+	drv := newDriver(d, nil, &commitMgrs{})
+	before, err := nodeutil.WriteJSON(b.Root())
+	fc.RequireEqual(t, nil, err)
+
+	tests := []struct {
+		name string
+		data string
+		code codes.Code
+	}{
+		{
+			name: "valid",
+			data: `{"name":"barb","server":[{"id":3,"host":"c","port":80}]}`,
+		},
+		{
+			name: "must",
+			data: `{"max":0}`,
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unique",
+			data: `{"server":[{"id":3,"host":"a","port":80}]}`,
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, test := range tests {
+		req := &pb_gnmi.SetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Update: []*pb_gnmi.Update{{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "c"}}},
+				Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(test.data)}},
+			}},
+			Extension: []*gnmi_ext.Extension{ValidateOnly},
+		}
+		resp, err := drv.Set(context.TODO(), req)
+		fc.AssertEqual(t, test.code, status.Code(err), test.name, fmt.Sprint(err))
+		if test.code == codes.OK {
+			fc.AssertEqual(t, 1, len(resp.Response), test.name)
+		}
+		after, err := nodeutil.WriteJSON(b.Root())
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, before, after, test.name)
+	}
+
+	t.Run("mandatory", func(t *testing.T) {
+		req := &pb_gnmi.SetRequest{
+			Prefix: &pb_gnmi.Path{Origin: "x"},
+			Replace: []*pb_gnmi.Update{{
+				Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "c"}}},
+				Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(`{"c":{"max":2}}`)}},
+			}},
+		}
+		_, err := set(d, context.TODO(), req)
+		fc.AssertEqual(t, codes.InvalidArgument, status.Code(err))
+		after, err := nodeutil.WriteJSON(b.Root())
+		fc.AssertEqual(t, nil, err)
+		fc.AssertEqual(t, before, after)
+	})
+}
+
+func TestSetUncheckableMust(t *testing.T) {
+	mstr := `module x {
+		prefix x;
+		namespace x;
+		container c {
+			must "$limit > max";
+			leaf max {
+				type int32;
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+	d := device.New(nil)
+	d.AddBrowser(node.NewBrowser(m, nodeutil.ReflectChild(map[string]interface{}{})))
+	req := &pb_gnmi.SetRequest{
+		Prefix: &pb_gnmi.Path{Origin: "x"},
+		Update: []*pb_gnmi.Update{{
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "c"}}},
+			Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(`{"max":2}`)}},
+		}},
+	}
+	// live edits skip what cannot be checked
+	_, err = set(d, context.TODO(), req)
+	fc.AssertEqual(t, nil, err)
+
+	// validate-only has to be sure
+	req.Extension = []*gnmi_ext.Extension{ValidateOnly}
+	_, err = set(d, context.TODO(), req)
+	fc.AssertEqual(t, codes.Unimplemented, status.Code(err), fmt.Sprint(err))
+}
+
+func TestSetUnionReplace(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":  "joe",
+			"skill": "manager",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+		},
+	})
+	ystr := `module y {
+		prefix y;
+		namespace y;
+		container pet {
+			leaf kind {
+				type string;
+			}
+			leaf age {
+				type int32;
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(nil, ystr)
+	fc.RequireEqual(t, nil, err)
+	y := node.NewBrowser(m, nodeutil.ReflectChild(map[string]interface{}{
+		"pet": map[string]interface{}{"kind": "cat", "age": 3},
+	}))
+	dev.AddBrowser(y)
+	x, _ := dev.Browser("x")
+
+	union := func(origin string, data string) *pb_gnmi.Update {
+		return &pb_gnmi.Update{
+			Path: &pb_gnmi.Path{Origin: origin},
+			Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(data)}},
+		}
+	}
+	req := &pb_gnmi.SetRequest{
+		UnionReplace: []*pb_gnmi.Update{
+			union("x", `{"me":{"name":"barb"}}`),
+			union("y", `{"pet":{"kind":"dog"}}`),
+			union("x", `{"users":[{"name":"sue"}]}`),
+		},
+	}
+	resp, err := set(dev, context.TODO(), req)
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, 3, len(resp.Response))
+	fc.AssertEqual(t, pb_gnmi.UpdateResult_UNION_REPLACE, resp.Response[0].Op)
+	actual, err := nodeutil.WriteJSON(x.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `{"me":{"name":"barb"},"users":[{"name":"sue"}]}`, actual)
+	actual, err = nodeutil.WriteJSON(y.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `{"pet":{"kind":"dog"}}`, actual)
+
+	// all origins are left alone if any fail
+	req = &pb_gnmi.SetRequest{
+		UnionReplace: []*pb_gnmi.Update{
+			union("x", `{"me":{"name":"joe"}}`),
+			union("y", `{"pet":{"age":"old"}}`),
+		},
+	}
+	_, err = set(dev, context.TODO(), req)
+	fc.AssertEqual(t, true, err != nil)
+	actual, err = nodeutil.WriteJSON(x.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `{"me":{"name":"barb"},"users":[{"name":"sue"}]}`, actual)
+	actual, err = nodeutil.WriteJSON(y.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `{"pet":{"kind":"dog"}}`, actual)
+
+	// overlapping paths add to each other
+	users := &pb_gnmi.Update{
+		Path: &pb_gnmi.Path{Origin: "x", Elem: []*pb_gnmi.PathElem{{Name: "users"}}},
+		Val:  &pb_gnmi.TypedValue{Value: &pb_gnmi.TypedValue_JsonVal{JsonVal: []byte(`[{"name":"sue","skill":"welder"},{"name":"bob"}]`)}},
+	}
+	req = &pb_gnmi.SetRequest{
+		UnionReplace: []*pb_gnmi.Update{
+			union("x", `{"me":{"name":"joe"},"users":[{"name":"sue"}]}`),
+			users,
+		},
+	}
+	resp, err = set(dev, context.TODO(), req)
+	fc.RequireEqual(t, nil, err)
+	fc.AssertEqual(t, 2, len(resp.Response))
+	actual, err = nodeutil.WriteJSON(x.Root())
+	fc.AssertEqual(t, nil, err)
+	fc.AssertEqual(t, `{"me":{"name":"joe"},"users":[{"name":"bob"},{"name":"sue","skill":"welder"}]}`, actual)
+}
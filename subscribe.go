@@ -4,19 +4,23 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/freeconf/restconf/device"
 	"github.com/freeconf/yang/fc"
 	"github.com/freeconf/yang/node"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
 )
 
 type subscriptionSink func(*pb_gnmi.SubscribeResponse) error
 
 type subscriptionManager struct {
-	subs []reoccurringSubscription
+	subs   []reoccurringSubscription
+	polled []reoccurringSubscription
 }
 
 type reoccurringSubscription interface {
@@ -26,53 +30,169 @@ type reoccurringSubscription interface {
 
 var errNoSampleInterval = errors.New("no sample interval given")
 
-type subService struct {
-	subMgr subscriptionManager
-}
+var errPollBeforeSubscribe = errors.New("poll request received before subscribe request")
+
+type subService struct{}
 
-func (s *subService) subscribe(d device.Device, server pb_gnmi.GNMI_SubscribeServer) error {
+// deviceResolver is device for a target name
+type deviceResolver func(target string) (device.Device, error)
+
+func (s *subService) subscribe(resolve deviceResolver, server pb_gnmi.GNMI_SubscribeServer) error {
+	// subscriptions are per stream
+	mgr := &subscriptionManager{}
+	sink := lockedSink(server.Send)
 	for {
 		req, err := server.Recv()
-		if err != nil && req != nil {
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
-		if req != nil {
-			if err = s.handleSubscribeList(d, server.Context(), req, server.Send); err != nil {
+		switch x := req.Request.(type) {
+		case *pb_gnmi.SubscribeRequest_Subscribe:
+			d, err := resolve(x.Subscribe.Prefix.GetTarget())
+			if err != nil {
+				return err
+			}
+			if err = s.handleSubscribeList(d, server.Context(), mgr, req, sink); err != nil {
+				return err
+			}
+			// gNMI spec: target closes ONCE streams after the sync response
+			if x.Subscribe.Mode == pb_gnmi.SubscriptionList_ONCE {
+				return nil
+			}
+		case *pb_gnmi.SubscribeRequest_Poll:
+			if len(mgr.polled) == 0 {
+				return errPollBeforeSubscribe
+			}
+			if err = mgr.poll(); err != nil {
+				return err
+			}
+			if err = sendSync(sink); err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// lockedSink serializes sends as ticker goroutines and the stream's receive
+// loop all send responses and grpc streams do not allow concurrent sends
+func lockedSink(sink subscriptionSink) subscriptionSink {
+	var mu sync.Mutex
+	return func(resp *pb_gnmi.SubscribeResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return sink(resp)
+	}
+}
+
+func sendSync(sink subscriptionSink) error {
+	return sink(&pb_gnmi.SubscribeResponse{
+		Response: &pb_gnmi.SubscribeResponse_SyncResponse{
+			SyncResponse: true,
+		},
+	})
+}
+
 // according to gNMI spec, this is for config or metrics only, not YANG notifications!
-func (s *subService) handleSubscribeList(d device.Device, ctx context.Context, req *pb_gnmi.SubscribeRequest, sink subscriptionSink) error {
+func (s *subService) handleSubscribeList(d device.Device, ctx context.Context, mgr *subscriptionManager, req *pb_gnmi.SubscribeRequest, sink subscriptionSink) error {
 	list := req.GetSubscribe()
 
-	prefix, err := selectPath(d, list.UseModels, list.Prefix)
-	if err != nil {
+	if err := checkEncoding(list.Encoding); err != nil {
 		return err
 	}
 
 	for _, subReq := range list.Subscription {
 		fc.Debug.Printf("new sub mode = %d", list.Mode)
+		// path in several models is a subscription for each so notifications
+		// are per origin
+		prefixes, err := selectPrefixes(d, ctx, list.UseModels, list.Prefix, subReq.Path)
+		if err != nil {
+			return withPath(err, subReq.Path)
+		}
+		for _, prefix := range prefixes {
+			sub := newSubscription(d, ctx, prefix, subReq, sink)
+			sub.encoding = list.Encoding
+			sub.leafUpdates = list.Encoding == pb_gnmi.Encoding_PROTO
+			sub.notifyPrefix = notificationPrefix(prefix.Browser.Meta.Ident(), list.Prefix)
+			if err := startSubscription(ctx, mgr, list, sub); err != nil {
+				return err
+			}
+		}
+	}
+
+	// let client know initial snapshot is complete
+	return sendSync(sink)
+}
+
+func startSubscription(ctx context.Context, mgr *subscriptionManager, list *pb_gnmi.SubscriptionList, sub *subscription) error {
+	// subscription modes only apply to streams
+	if list.Mode == pb_gnmi.SubscriptionList_STREAM && sub.opts.Mode == pb_gnmi.SubscriptionMode_TARGET_DEFINED {
+		if err := sub.resolveTargetDefined(); err != nil {
+			return withPath(err, sub.opts.Path)
+		}
+	}
+
+	// execute once sychronously avoids kicking off threads and runs thru
+	// sub to validate paths
+	initial := sub.execute
+	if list.UpdatesOnly {
+		initial = sub.prime
+	}
+	if err := initial(); err != nil {
+		return withPath(err, sub.opts.Path)
+	}
+	switch list.Mode {
+	case pb_gnmi.SubscriptionList_ONCE:
+	case pb_gnmi.SubscriptionList_POLL:
+		mgr.addPoll(sub)
+	default:
+		return mgr.add(ctx, sub)
+	}
+	return nil
+}
 
-		sub := newSubscription(d, prefix, subReq, sink)
+// addPoll registers subscription w/o a ticker, it executes only when client
+// sends a poll request
+func (mgr *subscriptionManager) addPoll(sub reoccurringSubscription) {
+	mgr.polled = append(mgr.polled, sub)
+}
 
-		// execute once sychronously avoids kicking off threads and runs thru
-		// sub to validate paths
+func (mgr *subscriptionManager) poll() error {
+	for _, sub := range mgr.polled {
 		if err := sub.execute(); err != nil {
 			return err
 		}
-		if list.Mode != pb_gnmi.SubscriptionList_ONCE {
-			if err := s.subMgr.add(ctx, sub); err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
 
+// changeWatcher is implemented by subscriptions that can be notified of data
+// changes instead of sampling data
+type changeWatcher interface {
+	reoccurringSubscription
+
+	// watch returns nil channel if subscription is not interested in changes.
+	// Edits only signal changes to config so when sample is not zero data is
+	// also sampled that often for changes made any other way.
+	watch() (changes <-chan struct{}, unwatch func(), sample time.Duration, err error)
+
+	getHeartbeatInterval() time.Duration
+}
+
 func (mgr *subscriptionManager) add(ctx context.Context, sub reoccurringSubscription) error {
+	if w, valid := sub.(changeWatcher); valid {
+		changes, unwatch, sample, err := w.watch()
+		if err != nil {
+			return err
+		}
+		if changes != nil {
+			mgr.subs = append(mgr.subs, sub)
+			go mgr.runOnChange(ctx, w, changes, unwatch, sample)
+			return nil
+		}
+	}
 	fc.Debug.Printf("starting ticker with sample rate %s", sub.getSampleInterval())
 	sample := sub.getSampleInterval()
 	if sample == 0 {
@@ -98,13 +218,75 @@ func (mgr *subscriptionManager) add(ctx context.Context, sub reoccurringSubscrip
 	return nil
 }
 
+// runOnChange executes subscription when data changes and on every heartbeat
+func (mgr *subscriptionManager) runOnChange(ctx context.Context, sub changeWatcher, changes <-chan struct{}, unwatch func(), sample time.Duration) {
+	defer unwatch()
+	var heartbeat <-chan time.Time
+	if interval := sub.getHeartbeatInterval(); interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+	var sampler <-chan time.Time
+	if sample > 0 {
+		t := time.NewTicker(sample)
+		defer t.Stop()
+		sampler = t.C
+	}
+	for {
+		select {
+		case <-changes:
+			fc.Debug.Printf("change detected")
+		case <-sampler:
+			fc.Debug.Printf("ticker fired")
+		case <-heartbeat:
+			fc.Debug.Printf("heartbeat fired")
+		case <-ctx.Done():
+			return
+		}
+		if err := sub.execute(); err != nil {
+			fc.Err.Printf("cannot get sub %s", err)
+		}
+	}
+}
+
 type subscription struct {
-	device        device.Device
-	prefix        *node.Selection
-	sink          subscriptionSink
-	opts          *pb_gnmi.Subscription
-	previousValue *pb_gnmi.TypedValue
-	previousTime  time.Time
+	device       device.Device
+	ctx          context.Context
+	prefix       *node.Selection
+	sink         subscriptionSink
+	opts         *pb_gnmi.Subscription
+	previous     *sample
+	previousTime time.Time
+
+	// JSON or JSON_IETF unless sending leaf updates
+	encoding pb_gnmi.Encoding
+
+	// send an update per leaf instead of a JSON value
+	leafUpdates bool
+
+	// prefix on notifications sent
+	notifyPrefix *pb_gnmi.Path
+}
+
+// sample is what was found on a single execution of a subscription
+type sample struct {
+	// JSON values by path matched
+	values map[string]*pb_gnmi.TypedValue
+
+	// leaf values by leaf path when sending updates per leaf
+	leaves map[string]*pb_gnmi.TypedValue
+
+	// every container, list item and leaf to detect removals
+	paths map[string]*pb_gnmi.Path
+}
+
+func newSample() *sample {
+	return &sample{
+		values: make(map[string]*pb_gnmi.TypedValue),
+		leaves: make(map[string]*pb_gnmi.TypedValue),
+		paths:  make(map[string]*pb_gnmi.Path),
+	}
 }
 
 func (s *subscription) getHeartbeatInterval() time.Duration {
@@ -118,55 +300,215 @@ func (s *subscription) getSampleInterval() time.Duration {
 	return time.Duration(s.opts.SampleInterval) * time.Nanosecond
 }
 
-func newSubscription(d device.Device, prefix *node.Selection, opts *pb_gnmi.Subscription, sink subscriptionSink) *subscription {
+func newSubscription(d device.Device, ctx context.Context, prefix *node.Selection, opts *pb_gnmi.Subscription, sink subscriptionSink) *subscription {
 	return &subscription{
-		device: d,
-		prefix: prefix,
-		opts:   opts,
-		sink:   sink,
+		device:   d,
+		ctx:      ctx,
+		prefix:   prefix,
+		opts:     opts,
+		sink:     sink,
+		previous: newSample(),
 	}
 }
 
-func (s *subscription) execute() error {
-	sel, err := advanceSelection(s.device, s.prefix, s.opts.Path)
-	fc.Debug.Printf("sub request %s", sel.Path)
-	if err != nil {
+// resolveTargetDefined lets YANG definition of subscription path decide
+// between ON_CHANGE and SAMPLE
+func (s *subscription) resolveTargetDefined() error {
+	matches, err := selectAll(s.device, s.ctx, s.prefix, s.opts.Path)
+	if err != nil || len(matches) == 0 {
 		return err
 	}
-	val, err := getVal(sel)
-	if err != nil {
-		return err
+	resolved := targetDefined(matches[0].sel.Path.Meta, s.opts)
+	for _, m := range matches[1:] {
+		// any match that needs sampling means all are sampled and any match
+		// that changes often means all samples are sent
+		alt := targetDefined(m.sel.Path.Meta, s.opts)
+		if alt.Mode == pb_gnmi.SubscriptionMode_SAMPLE {
+			if resolved.Mode != pb_gnmi.SubscriptionMode_SAMPLE || resolved.SuppressRedundant {
+				resolved = alt
+			}
+		}
 	}
+	s.opts = resolved
+	fc.Debug.Printf("target defined mode for %s is %s", matches[0].sel.Path, s.opts.Mode)
+	return nil
+}
 
-	now := time.Now()
-	if s.previousValue != nil {
-		if now.Sub(s.previousTime) < s.getHeartbeatInterval() {
-			if s.opts.Mode == pb_gnmi.SubscriptionMode_ON_CHANGE {
-				if isEqualValues(s.previousValue, val) {
-					return nil
-				}
+// watch listens for edits made thru the browser behind the subscription path
+// which includes gNMI Set, RESTCONF or any other FreeCONF edits.  Edits at,
+// above or below the path signal a change. Paths w/wildcards watch from the
+// point the first wildcard appears.  State data and changes a device makes on
+// its own are not edits so those are found by sampling.
+func (s *subscription) watch() (<-chan struct{}, func(), time.Duration, error) {
+	if s.opts.Mode != pb_gnmi.SubscriptionMode_ON_CHANGE {
+		return nil, nil, 0, nil
+	}
+	// paths that do not exist yet are watched from nearest parent that does
+	sel, _, err := selectExisting(s.device, s.ctx, s.prefix, concretePath(s.opts.Path))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	sample := time.Duration(s.opts.SampleInterval) * time.Nanosecond
+	if sample == 0 && !isAllConfig(sel.Meta()) {
+		sample = DefaultSampleInterval
+	}
+	target := sel.Path.String()
+	// buffer of one coalesces several edits into single execution
+	changes := make(chan struct{}, 1)
+	unwatch := browserChangeFeed(sel.Browser).listen(func(r node.NodeRequest) {
+		if r.Source == nil {
+			return
+		}
+		edited := r.Source.Path.String()
+		if isPathPrefix(target, edited) || isPathPrefix(edited, target) {
+			select {
+			case changes <- struct{}{}:
+			default:
 			}
 		}
-	}
+	})
+	return changes, unwatch, sample, nil
+}
 
-	update := &pb_gnmi.Update{
-		Path: s.opts.Path,
-		Val:  val,
+func (s *subscription) execute() error {
+	now := time.Now()
+	n, err := s.notification(now)
+	if err != nil || n == nil {
+		return err
 	}
-	resp := &pb_gnmi.SubscribeResponse{
+	s.previousTime = now
+	return s.sink(&pb_gnmi.SubscribeResponse{
 		Response: &pb_gnmi.SubscribeResponse_Update{
-			Update: &pb_gnmi.Notification{
-				Timestamp: now.UnixNano(),
-				Update:    []*pb_gnmi.Update{update},
-			},
+			Update: n,
 		},
+	})
+}
+
+// prime records current data w/o sending it so only subsequent updates are
+// sent.  This is for clients that asked for updates only.
+func (s *subscription) prime() error {
+	now := time.Now()
+	if _, err := s.notification(now); err != nil {
+		return err
 	}
-	s.sink(resp)
-	s.previousValue = val
 	s.previousTime = now
 	return nil
 }
 
+// notification is nil when there is nothing to send
+func (s *subscription) notification(now time.Time) (*pb_gnmi.Notification, error) {
+	matches, err := selectAll(s.device, s.ctx, s.prefix, s.opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	current := newSample()
+	var updates []*pb_gnmi.Update
+	for _, m := range matches {
+		fc.Debug.Printf("sub request %s", m.sel.Path)
+		if err := checkAccess(m.sel, PermRead, true); err != nil {
+			return nil, err
+		}
+		var changed []*pb_gnmi.Update
+		if s.leafUpdates {
+			changed, err = s.changedLeaves(m, now, current)
+		} else {
+			changed, err = s.changedValue(m, now, current)
+		}
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, changed...)
+	}
+	deletes := removedPaths(s.previous.paths, current.paths)
+	s.previous = current
+	if len(updates) == 0 && len(deletes) == 0 {
+		return nil, nil
+	}
+	return &pb_gnmi.Notification{
+		Timestamp: now.UnixNano(),
+		Prefix:    s.notifyPrefix,
+		Update:    updates,
+		Delete:    deletes,
+	}, nil
+}
+
+// skipUnchanged is true if unchanged data does not need to be sent because
+// subscription is for changes only or sampling with redundant data suppressed
+// and heartbeat is not due
+func (s *subscription) skipUnchanged(now time.Time) bool {
+	switch s.opts.Mode {
+	case pb_gnmi.SubscriptionMode_ON_CHANGE:
+	case pb_gnmi.SubscriptionMode_SAMPLE:
+		if !s.opts.SuppressRedundant {
+			return false
+		}
+	default:
+		return false
+	}
+	heartbeat := s.getHeartbeatInterval()
+	return heartbeat == 0 || now.Sub(s.previousTime) < heartbeat
+}
+
+// changedValue is whole subtree as a single JSON value
+func (s *subscription) changedValue(m pathMatch, now time.Time, current *sample) ([]*pb_gnmi.Update, error) {
+	val, err := getVal(m.sel, s.encoding)
+	if err != nil {
+		return nil, err
+	}
+	key := pathString(basePath(m.path))
+	current.values[key] = val
+	if isEqualValues(s.previous.values[key], val) {
+		// nothing could have been removed either
+		for k, p := range s.previous.paths {
+			if isPathStringPrefix(key, k) {
+				current.paths[k] = p
+			}
+		}
+		if s.skipUnchanged(now) {
+			return nil, nil
+		}
+	} else {
+		c, err := collectLeaves(m.sel, basePath(m.path))
+		if err != nil {
+			return nil, err
+		}
+		for k, p := range c.paths {
+			current.paths[k] = p
+		}
+	}
+	return []*pb_gnmi.Update{{Path: m.path, Val: val}}, nil
+}
+
+// changedLeaves is each leaf in subtree as a separate update, only leaves that
+// changed if unchanged leaves can be skipped
+func (s *subscription) changedLeaves(m pathMatch, now time.Time, current *sample) ([]*pb_gnmi.Update, error) {
+	c, err := collectLeaves(m.sel, basePath(m.path))
+	if err != nil {
+		return nil, err
+	}
+	skip := s.skipUnchanged(now)
+	var changed []*pb_gnmi.Update
+	for _, leaf := range c.updates {
+		key := pathString(leaf.Path)
+		current.leaves[key] = leaf.Val
+		if skip && proto.Equal(s.previous.leaves[key], leaf.Val) {
+			continue
+		}
+		changed = append(changed, leaf)
+	}
+	for k, p := range c.paths {
+		current.paths[k] = p
+	}
+	return changed, nil
+}
+
+func basePath(p *pb_gnmi.Path) *pb_gnmi.Path {
+	if p == nil {
+		return &pb_gnmi.Path{}
+	}
+	return p
+}
+
 func isEqualValues(a, b *pb_gnmi.TypedValue) bool {
 	if a == nil && b == nil {
 		return true
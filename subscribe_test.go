@@ -2,11 +2,20 @@ package gnmi
 
 import (
 	"context"
+	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/freeconf/restconf/device"
 	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/parser"
+	"github.com/freeconf/yang/val"
 	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
 )
 
 func TestSub(t *testing.T) {
@@ -37,7 +46,7 @@ func TestSub(t *testing.T) {
 			actual = update.Update.Update[0].Val.GetJsonVal()
 			return nil
 		}
-		sub := newSubscription(dev, prefix, opts, sink)
+		sub := newSubscription(dev, context.TODO(), prefix, opts, sink)
 		err := sub.execute()
 		fc.AssertEqual(t, nil, err)
 		// NOTE: same gold file as TestGet as they should match
@@ -55,7 +64,7 @@ func TestSub(t *testing.T) {
 			at = time.Now()
 			return nil
 		}
-		sub := newSubscription(dev, prefix, opts, sink)
+		sub := newSubscription(dev, context.TODO(), prefix, opts, sink)
 		err := sub.execute()
 		fc.AssertEqual(t, nil, err)
 		fc.AssertEqual(t, false, at.IsZero())
@@ -69,6 +78,361 @@ func TestSub(t *testing.T) {
 		fc.AssertEqual(t, nil, err)
 		fc.AssertEqual(t, false, t0 == at)
 	})
+
+	t.Run("suppressRedundant", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode:              pb_gnmi.SubscriptionMode_SAMPLE,
+			SampleInterval:    10 * uint64(time.Millisecond),
+			HeartbeatInterval: 40 * uint64(time.Millisecond),
+			SuppressRedundant: true,
+		}
+		sends := 0
+		sink := func(resp *pb_gnmi.SubscribeResponse) error {
+			sends++
+			return nil
+		}
+		sub := newSubscription(dev, context.TODO(), prefix, opts, sink)
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 1, sends)
+
+		data["me"].(map[string]interface{})["skill"] = "welder"
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 2, sends)
+
+		<-time.After(sub.getHeartbeatInterval())
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 3, sends)
+	})
+
+	t.Run("unchanged list", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode: pb_gnmi.SubscriptionMode_SAMPLE,
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "users"}}},
+		}
+		var deletes []*pb_gnmi.Path
+		sends := 0
+		sink := func(resp *pb_gnmi.SubscribeResponse) error {
+			sends++
+			deletes = append(deletes, resp.GetUpdate().Delete...)
+			return nil
+		}
+		sub := newSubscription(dev, context.TODO(), prefix, opts, sink)
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 2, sends)
+		fc.AssertEqual(t, 0, len(deletes))
+	})
+
+	t.Run("prime", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+		}
+		sends := 0
+		sink := func(resp *pb_gnmi.SubscribeResponse) error {
+			sends++
+			return nil
+		}
+		sub := newSubscription(dev, context.TODO(), prefix, opts, sink)
+		fc.AssertEqual(t, nil, sub.prime())
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 0, sends)
+
+		data["me"].(map[string]interface{})["skill"] = "manager"
+		fc.AssertEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 1, sends)
+	})
+}
+
+func TestSubOnChange(t *testing.T) {
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name": "joe",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+		},
+	}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	opts := &pb_gnmi.Subscription{
+		Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+		Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}},
+	}
+	updates := make(chan string, 10)
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		update := resp.Response.(*pb_gnmi.SubscribeResponse_Update)
+		updates <- string(update.Update.Update[0].Val.GetJsonVal())
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := newSubscription(dev, ctx, b.Root(), opts, sink)
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, `{"name":"joe"}`, <-updates)
+
+	mgr := &subscriptionManager{}
+	fc.RequireEqual(t, nil, mgr.add(ctx, sub))
+
+	set := func(path string, data string) {
+		sel, err := b.Root().Find(path)
+		fc.RequireEqual(t, nil, err)
+		n, err := nodeutil.ReadJSON(data)
+		fc.RequireEqual(t, nil, err)
+		fc.RequireEqual(t, nil, sel.UpsertFrom(n))
+	}
+	expectUpdate := func(expected string) {
+		select {
+		case actual := <-updates:
+			fc.AssertEqual(t, expected, actual)
+		case <-time.After(time.Second):
+			t.Errorf("no update for %s", expected)
+		}
+	}
+
+	set("me", `{"name":"barb"}`)
+	expectUpdate(`{"name":"barb"}`)
+
+	// edits elsewhere are not changes to this subscription
+	set("users=mary", `{"skill":"mechanic"}`)
+	set("me", `{"name":"joe"}`)
+	expectUpdate(`{"name":"joe"}`)
+
+	cancel()
+	<-time.After(10 * time.Millisecond)
+	set("me", `{"name":"barb"}`)
+	<-time.After(10 * time.Millisecond)
+	fc.AssertEqual(t, 0, len(updates))
+}
+
+func TestSubLeafUpdates(t *testing.T) {
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":  "joe",
+			"skill": "manager",
+		},
+	}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	opts := &pb_gnmi.Subscription{
+		Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+		Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}},
+	}
+	var actual []string
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		actual = nil
+		for _, u := range resp.GetUpdate().Update {
+			actual = append(actual, pathString(u.Path)+"="+u.Val.GetStringVal())
+		}
+		return nil
+	}
+	sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+	sub.leafUpdates = true
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, "/me/name=joe,/me/skill=manager", strings.Join(actual, ","))
+
+	data["me"].(map[string]interface{})["skill"] = "welder"
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, "/me/skill=welder", strings.Join(actual, ","))
+
+	actual = nil
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, 0, len(actual))
+}
+
+func TestSubMissing(t *testing.T) {
+	data := map[string]interface{}{}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	opts := &pb_gnmi.Subscription{
+		Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+		Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}, {Name: "name"}}},
+	}
+	var actual []string
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		for _, u := range resp.GetUpdate().Update {
+			actual = append(actual, pathString(u.Path)+"="+string(u.Val.GetJsonVal()))
+		}
+		return nil
+	}
+	sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, 0, len(actual))
+
+	data["me"] = map[string]interface{}{"name": "joe"}
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, `/me/name="joe"`, strings.Join(actual, ","))
+}
+
+func TestSubDelete(t *testing.T) {
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name": "joe",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+			{"name": "john", "skill": "mechanic"},
+		},
+	}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	var actual []string
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		actual = nil
+		for _, p := range resp.GetUpdate().Delete {
+			actual = append(actual, pathString(p))
+		}
+		return nil
+	}
+	del := func(path string) {
+		sel, err := b.Root().Find(path)
+		fc.RequireEqual(t, nil, err)
+		fc.RequireEqual(t, nil, sel.Delete())
+	}
+
+	t.Run("list", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+			Path: &pb_gnmi.Path{},
+		}
+		sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+		fc.RequireEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 0, len(actual))
+
+		del("users=mary")
+		fc.RequireEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, "/users[name=mary]", strings.Join(actual, ","))
+	})
+
+	t.Run("leaf", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "users"}}},
+		}
+		sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+		sub.leafUpdates = true
+		fc.RequireEqual(t, nil, sub.execute())
+
+		delete(data["users"].([]map[string]interface{})[0], "skill")
+		fc.RequireEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, "/users[name=john]/skill", strings.Join(actual, ","))
+	})
+
+	t.Run("target", func(t *testing.T) {
+		opts := &pb_gnmi.Subscription{
+			Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+			Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}},
+		}
+		sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+		fc.RequireEqual(t, nil, sub.execute())
+
+		del("me")
+		fc.RequireEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, "/me", strings.Join(actual, ","))
+
+		actual = nil
+		fc.RequireEqual(t, nil, sub.execute())
+		fc.AssertEqual(t, 0, len(actual))
+	})
+}
+
+func TestSubWildcard(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder"},
+			{"name": "john", "skill": "mechanic"},
+		},
+	}
+	dev := newTestDevice(data)
+	b, _ := dev.Browser("x")
+	opts := &pb_gnmi.Subscription{
+		Mode: pb_gnmi.SubscriptionMode_ON_CHANGE,
+		Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{
+			{Name: "users", Key: map[string]string{"name": "*"}},
+			{Name: "skill"},
+		}},
+	}
+	var actual []string
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		actual = nil
+		for _, u := range resp.GetUpdate().Update {
+			actual = append(actual, pathString(u.Path)+"="+string(u.Val.GetJsonVal()))
+		}
+		for _, p := range resp.GetUpdate().Delete {
+			actual = append(actual, "-"+pathString(p))
+		}
+		return nil
+	}
+	sub := newSubscription(dev, context.TODO(), b.Root(), opts, sink)
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, `/users[name=mary]/skill="welder",/users[name=john]/skill="mechanic"`, strings.Join(actual, ","))
+
+	data["users"].([]map[string]interface{})[1]["skill"] = "welder"
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, `/users[name=john]/skill="welder"`, strings.Join(actual, ","))
+
+	data["users"] = data["users"].([]map[string]interface{})[1:]
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, "-/users[name=mary]/skill", strings.Join(actual, ","))
+}
+
+func TestSubOnChangeSample(t *testing.T) {
+	m, err := parser.LoadModuleFromString(nil, mstr)
+	fc.RequireEqual(t, nil, err)
+
+	// device changes name on its own so there is no edit to watch
+	var mu sync.Mutex
+	name := "joe"
+	me := &nodeutil.Basic{
+		OnField: func(r node.FieldRequest, hnd *node.ValueHandle) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Meta.Ident() == "name" {
+				hnd.Val = val.String(name)
+			}
+			return nil
+		},
+	}
+	n := &nodeutil.Basic{
+		OnChild: func(r node.ChildRequest) (node.Node, error) {
+			if r.Meta.Ident() == "me" {
+				return me, nil
+			}
+			return nil, nil
+		},
+	}
+	dev := device.New(nil)
+	dev.AddBrowser(node.NewBrowser(m, n))
+	b, _ := dev.Browser("x")
+	opts := &pb_gnmi.Subscription{
+		Mode:           pb_gnmi.SubscriptionMode_ON_CHANGE,
+		Path:           &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}},
+		SampleInterval: 10 * uint64(time.Millisecond),
+	}
+	updates := make(chan string, 10)
+	sink := func(resp *pb_gnmi.SubscribeResponse) error {
+		update := resp.Response.(*pb_gnmi.SubscribeResponse_Update)
+		updates <- string(update.Update.Update[0].Val.GetJsonVal())
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := newSubscription(dev, ctx, b.Root(), opts, sink)
+	fc.RequireEqual(t, nil, sub.execute())
+	fc.AssertEqual(t, `{"name":"joe"}`, <-updates)
+
+	mgr := &subscriptionManager{}
+	fc.RequireEqual(t, nil, mgr.add(ctx, sub))
+
+	mu.Lock()
+	name = "barb"
+	mu.Unlock()
+	select {
+	case actual := <-updates:
+		fc.AssertEqual(t, `{"name":"barb"}`, actual)
+	case <-time.After(time.Second):
+		t.Error("no update from sampling")
+	}
 }
 
 func TestSubMgr(t *testing.T) {
@@ -104,6 +468,166 @@ func TestSubMgr(t *testing.T) {
 	})
 }
 
+func TestSubscribe(t *testing.T) {
+	dev := newTestDevice(map[string]interface{}{
+		"me": map[string]interface{}{
+			"name": "joe",
+		},
+	})
+	stream := &testSubStream{
+		ctx: context.Background(),
+		reqs: []*pb_gnmi.SubscribeRequest{
+			{
+				Request: &pb_gnmi.SubscribeRequest_Subscribe{
+					Subscribe: &pb_gnmi.SubscriptionList{
+						Mode:      pb_gnmi.SubscriptionList_POLL,
+						UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
+						Subscription: []*pb_gnmi.Subscription{
+							{Path: &pb_gnmi.Path{}},
+						},
+					},
+				},
+			},
+			{Request: &pb_gnmi.SubscribeRequest_Poll{Poll: &pb_gnmi.Poll{}}},
+			{Request: &pb_gnmi.SubscribeRequest_Poll{Poll: &pb_gnmi.Poll{}}},
+		},
+	}
+	svc := &subService{}
+	fc.AssertEqual(t, nil, svc.subscribe(singleDevice(dev), stream))
+	fc.AssertEqual(t, "update,sync,update,sync,update,sync", stream.summary())
+
+	t.Run("once", func(t *testing.T) {
+		stream := &testSubStream{
+			ctx: context.Background(),
+			reqs: []*pb_gnmi.SubscribeRequest{
+				{
+					Request: &pb_gnmi.SubscribeRequest_Subscribe{
+						Subscribe: &pb_gnmi.SubscriptionList{
+							Mode:      pb_gnmi.SubscriptionList_ONCE,
+							UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
+							Subscription: []*pb_gnmi.Subscription{
+								{Path: &pb_gnmi.Path{}},
+								{Path: &pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{{Name: "me"}}}},
+							},
+						},
+					},
+				},
+				// stream should be closed before this is read
+				{Request: &pb_gnmi.SubscribeRequest_Poll{Poll: &pb_gnmi.Poll{}}},
+			},
+		}
+		fc.AssertEqual(t, nil, svc.subscribe(singleDevice(dev), stream))
+		fc.AssertEqual(t, "update,update,sync", stream.summary())
+		fc.AssertEqual(t, 1, len(stream.reqs))
+	})
+
+	t.Run("updates only", func(t *testing.T) {
+		stream := &testSubStream{
+			ctx: context.Background(),
+			reqs: []*pb_gnmi.SubscribeRequest{
+				{
+					Request: &pb_gnmi.SubscribeRequest_Subscribe{
+						Subscribe: &pb_gnmi.SubscriptionList{
+							Mode:        pb_gnmi.SubscriptionList_ONCE,
+							UpdatesOnly: true,
+							UseModels:   []*pb_gnmi.ModelData{{Name: "x"}},
+							Subscription: []*pb_gnmi.Subscription{
+								{Path: &pb_gnmi.Path{}},
+							},
+						},
+					},
+				},
+			},
+		}
+		fc.AssertEqual(t, nil, svc.subscribe(singleDevice(dev), stream))
+		fc.AssertEqual(t, "sync", stream.summary())
+	})
+
+	t.Run("multiple models", func(t *testing.T) {
+		dev := newTestDevice(map[string]interface{}{
+			"me": map[string]interface{}{"name": "joe"},
+		})
+		addPetModule(dev, map[string]interface{}{
+			"pet": map[string]interface{}{"kind": "cat"},
+		})
+		stream := &testSubStream{
+			ctx: context.Background(),
+			reqs: []*pb_gnmi.SubscribeRequest{
+				{
+					Request: &pb_gnmi.SubscribeRequest_Subscribe{
+						Subscribe: &pb_gnmi.SubscriptionList{
+							Mode:      pb_gnmi.SubscriptionList_ONCE,
+							UseModels: []*pb_gnmi.ModelData{{Name: "x"}, {Name: "pets"}},
+							Subscription: []*pb_gnmi.Subscription{
+								{Path: &pb_gnmi.Path{}},
+							},
+						},
+					},
+				},
+			},
+		}
+		fc.AssertEqual(t, nil, svc.subscribe(singleDevice(dev), stream))
+		fc.AssertEqual(t, "update,update,sync", stream.summary())
+		fc.AssertEqual(t, "x", stream.resps[0].GetUpdate().Prefix.Origin)
+		fc.AssertEqual(t, "pets", stream.resps[1].GetUpdate().Prefix.Origin)
+	})
+
+	t.Run("poll first", func(t *testing.T) {
+		stream := &testSubStream{
+			ctx: context.Background(),
+			reqs: []*pb_gnmi.SubscribeRequest{
+				{Request: &pb_gnmi.SubscribeRequest_Poll{Poll: &pb_gnmi.Poll{}}},
+			},
+		}
+		fc.AssertEqual(t, errPollBeforeSubscribe, svc.subscribe(singleDevice(dev), stream))
+	})
+}
+
+func singleDevice(d device.Device) deviceResolver {
+	return func(string) (device.Device, error) {
+		return d, nil
+	}
+}
+
+// testSubStream replays requests and records responses
+type testSubStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	reqs  []*pb_gnmi.SubscribeRequest
+	resps []*pb_gnmi.SubscribeResponse
+}
+
+func (s *testSubStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testSubStream) Recv() (*pb_gnmi.SubscribeRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *testSubStream) Send(resp *pb_gnmi.SubscribeResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
+
+func (s *testSubStream) summary() string {
+	var summary []string
+	for _, resp := range s.resps {
+		switch resp.Response.(type) {
+		case *pb_gnmi.SubscribeResponse_Update:
+			summary = append(summary, "update")
+		case *pb_gnmi.SubscribeResponse_SyncResponse:
+			summary = append(summary, "sync")
+		}
+	}
+	return strings.Join(summary, ",")
+}
+
 type dummySub struct {
 	sample time.Duration
 	at     time.Time
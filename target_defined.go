@@ -0,0 +1,91 @@
+package gnmi
+
+import (
+	"time"
+
+	"github.com/freeconf/yang/meta"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultSampleInterval is used for TARGET_DEFINED subscriptions that are
+// sampled when client does not give a sample interval
+var DefaultSampleInterval = 10 * time.Second
+
+const (
+	extModule             = "fc-gnmi-ext"
+	slowChangingExtension = "slow-changing"
+)
+
+// targetDefined replaces TARGET_DEFINED mode w/ON_CHANGE when all data under
+// definition is config, otherwise w/SAMPLE. Edits only signal changes to config
+// so state is always sampled, but state marked slow changing is only sent when
+// it differs from last sample.
+func targetDefined(m meta.Definition, opts *pb_gnmi.Subscription) *pb_gnmi.Subscription {
+	resolved := proto.Clone(opts).(*pb_gnmi.Subscription)
+	if isAllConfig(m) {
+		resolved.Mode = pb_gnmi.SubscriptionMode_ON_CHANGE
+		return resolved
+	}
+	resolved.Mode = pb_gnmi.SubscriptionMode_SAMPLE
+	if resolved.SampleInterval == 0 {
+		resolved.SampleInterval = uint64(DefaultSampleInterval.Nanoseconds())
+	}
+	if isSlowChanging(m) {
+		resolved.SuppressRedundant = true
+	}
+	return resolved
+}
+
+// isSlowChanging is true if every leaf under m is config or marked with
+// fc-gnmi-ext:slow-changing on itself or any definition above it
+func isSlowChanging(m meta.Definition) bool {
+	for p := m.Parent(); p != nil; p = p.Parent() {
+		if hasSlowChangingExtension(p) {
+			return true
+		}
+	}
+	return isSlowChangingUnder(m)
+}
+
+func isSlowChangingUnder(m meta.Definition) bool {
+	if hasSlowChangingExtension(m) {
+		return true
+	}
+	switch x := m.(type) {
+	case meta.Leafable:
+		if c, valid := x.(meta.HasConfig); valid {
+			return c.Config()
+		}
+		return false
+	case *meta.Choice:
+		for _, kase := range x.Cases() {
+			if !isSlowChangingUnder(kase) {
+				return false
+			}
+		}
+	case meta.HasDataDefinitions:
+		for _, child := range x.DataDefinitions() {
+			if !isSlowChangingUnder(child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasSlowChangingExtension only counts slow-changing from fc-gnmi-ext, other
+// modules are free to define an extension w/same name
+func hasSlowChangingExtension(m meta.Meta) bool {
+	for _, ext := range m.Extensions() {
+		if ext.Keyword() != "" || ext.Ident() != slowChangingExtension {
+			continue
+		}
+		if def := ext.ExtDefinition(); def != nil {
+			if mod, valid := def.Parent().(*meta.Module); valid && mod.Ident() == extModule {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package gnmi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/parser"
+	"github.com/freeconf/yang/source"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestTargetDefined(t *testing.T) {
+	mstr := `module y {
+		prefix y;
+		import fc-gnmi-ext {
+			prefix gnmix;
+		}
+		extension slow-changing;
+		container cfg {
+			leaf name {
+				type string;
+			}
+			leaf status {
+				config false;
+				gnmix:slow-changing;
+				type string;
+			}
+		}
+		container stats {
+			config false;
+			leaf status {
+				gnmix:slow-changing;
+				type string;
+			}
+			leaf packets {
+				type int64;
+			}
+		}
+		container info {
+			config false;
+			gnmix:slow-changing;
+			leaf version {
+				type string;
+			}
+		}
+		container other {
+			config false;
+			y:slow-changing;
+			leaf version {
+				type string;
+			}
+		}
+	}`
+	m, err := parser.LoadModuleFromString(source.EmbedDir(internal, "yang"), mstr)
+	fc.RequireEqual(t, nil, err)
+	tests := []struct {
+		path     []string
+		expected pb_gnmi.SubscriptionMode
+		suppress bool
+	}{
+		{path: []string{"cfg", "name"}, expected: pb_gnmi.SubscriptionMode_ON_CHANGE},
+		{path: []string{"cfg"}, expected: pb_gnmi.SubscriptionMode_SAMPLE, suppress: true},
+		{path: []string{"cfg", "status"}, expected: pb_gnmi.SubscriptionMode_SAMPLE, suppress: true},
+		{path: []string{"stats"}, expected: pb_gnmi.SubscriptionMode_SAMPLE},
+		{path: []string{"stats", "status"}, expected: pb_gnmi.SubscriptionMode_SAMPLE, suppress: true},
+		{path: []string{"stats", "packets"}, expected: pb_gnmi.SubscriptionMode_SAMPLE},
+		{path: []string{"info"}, expected: pb_gnmi.SubscriptionMode_SAMPLE, suppress: true},
+		{path: []string{"info", "version"}, expected: pb_gnmi.SubscriptionMode_SAMPLE, suppress: true},
+		{path: []string{"other"}, expected: pb_gnmi.SubscriptionMode_SAMPLE},
+		{path: nil, expected: pb_gnmi.SubscriptionMode_SAMPLE},
+	}
+	for _, test := range tests {
+		var def meta.Definition = m
+		for _, ident := range test.path {
+			def = def.(meta.HasDataDefinitions).Definition(ident)
+		}
+		opts := &pb_gnmi.Subscription{Mode: pb_gnmi.SubscriptionMode_TARGET_DEFINED}
+		actual := targetDefined(def, opts)
+		fc.AssertEqual(t, test.expected, actual.Mode, test.path...)
+		fc.AssertEqual(t, test.suppress, actual.SuppressRedundant, test.path...)
+		if actual.Mode == pb_gnmi.SubscriptionMode_SAMPLE {
+			fc.AssertEqual(t, uint64(DefaultSampleInterval), actual.SampleInterval)
+		}
+	}
+
+	opts := &pb_gnmi.Subscription{
+		Mode:           pb_gnmi.SubscriptionMode_TARGET_DEFINED,
+		SampleInterval: uint64(time.Second),
+	}
+	actual := targetDefined(m, opts)
+	fc.AssertEqual(t, uint64(time.Second), actual.SampleInterval)
+	fc.AssertEqual(t, pb_gnmi.SubscriptionMode_TARGET_DEFINED, opts.Mode)
+}
@@ -0,0 +1,87 @@
+package gnmi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/freeconf/restconf/device"
+)
+
+// Devices finds device for a gNMI target name so a single server can be a
+// gateway to many devices. Set on Server to route requests by the target in
+// the request prefix.
+type Devices interface {
+	Device(target string) (device.Device, error)
+
+	// Targets are all target names, used to report models for Capabilities
+	Targets() []string
+}
+
+var errNoTarget = errors.New("no device for target")
+
+// DeviceMap is the default Devices, a registry of devices by target name
+// backed by FreeCONF's device map
+type DeviceMap struct {
+	mu      sync.RWMutex
+	devices *device.Map
+
+	// FreeCONF's device map only finds devices by id and cannot list them so
+	// target names are kept here as well for Targets
+	targets map[string]bool
+}
+
+func NewDeviceMap() *DeviceMap {
+	return &DeviceMap{
+		devices: device.NewMap(),
+		targets: make(map[string]bool),
+	}
+}
+
+// Add registers or replaces device for target
+func (m *DeviceMap) Add(target string, d device.Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices.Add(target, d)
+	m.targets[target] = true
+}
+
+func (m *DeviceMap) Device(target string) (device.Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.targets[target] {
+		return nil, fmt.Errorf("%w '%s'", errNoTarget, target)
+	}
+	return m.devices.Device(target)
+}
+
+// Targets in sorted order
+func (m *DeviceMap) Targets() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	targets := make([]string, 0, len(m.targets))
+	for t := range m.targets {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// gateway holds Devices that may be replaced while serving
+type gateway struct {
+	mu      sync.RWMutex
+	devices Devices
+}
+
+func (g *gateway) get() Devices {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.devices
+}
+
+func (g *gateway) set(devices Devices) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.devices = devices
+}
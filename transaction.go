@@ -0,0 +1,286 @@
+package gnmi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/nodeutil"
+	"github.com/freeconf/yang/val"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// transaction makes a SetRequest all-or-nothing. Before each operation the
+// config at the path it touches is saved so if any operation fails, each path
+// is put back the way it was.  Nothing outside those paths is touched.
+type transaction struct {
+	ctx   context.Context
+	undos []*undo
+
+	// strict rejects edits that cannot be fully validated
+	strict bool
+}
+
+// undo puts data at a single path back to how it was before an operation
+type undo struct {
+	browser *node.Browser
+
+	// path from module root in FreeCONF format (e.g. users=mary/name), empty for
+	// module root
+	path string
+
+	// path did not exist so undo deletes what was created
+	created bool
+
+	// leaf and its value when path is a leaf. nil value means leaf had no value
+	leaf  meta.Leafable
+	value val.Value
+
+	// config under path for containers, lists and list items
+	config interface{}
+}
+
+func newTransaction(ctx context.Context) *transaction {
+	return &transaction{ctx: ctx}
+}
+
+// save records state of data an operation at selection is about to change.
+// Missing are path elements under selection operation will create.
+func (tx *transaction) save(sel *node.Selection, missing []*pb_gnmi.PathElem) error {
+	if sel == nil {
+		return nil
+	}
+	u := &undo{browser: sel.Browser, path: relPath(sel)}
+	seg, err := findSegment(sel, missing)
+	if err != nil {
+		return err
+	}
+	// saved config is only put back so it includes what reads normally hide
+	saved := *sel
+	saved.Context = withPasswordHashes(sel.Context)
+	sel = &saved
+	if seg != "" {
+		u.path = joinPath(u.path, seg)
+		u.created = true
+	} else if leaf, isLeaf := sel.Meta().(meta.Leafable); isLeaf {
+		u.leaf = leaf
+		v, err := sel.Get()
+		if err != nil {
+			return err
+		}
+		u.value = v
+	} else {
+		data, err := nodeutil.WriteJSON(dataTypeFilter(pb_gnmi.GetRequest_CONFIG).apply(sel))
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal([]byte(data), &u.config); err != nil {
+			return err
+		}
+	}
+	tx.undos = append(tx.undos, u)
+	return nil
+}
+
+// findSegment is first missing element in FreeCONF path format
+func findSegment(sel *node.Selection, missing []*pb_gnmi.PathElem) (string, error) {
+	for _, e := range missing {
+		if e.GetName() == "" {
+			continue
+		}
+		ident := localName(e.Name)
+		if len(e.Key) == 0 {
+			return ident, nil
+		}
+		lmeta, valid := meta.Find(sel.Meta(), ident).(*meta.List)
+		if !valid {
+			return "", errKeysWhenNoList
+		}
+		return ident + "=" + encodeKey(lmeta, e.Key), nil
+	}
+	return "", nil
+}
+
+// relPath is selection's path from module root.  Path of leaf selections is
+// not always connected to parent so parent's path is used
+func relPath(sel *node.Selection) string {
+	if leaf, isLeaf := sel.Meta().(meta.Leafable); isLeaf {
+		return joinPath(relPath(sel.Parent()), leaf.Ident())
+	}
+	return sel.Path.StringNoModule()
+}
+
+func joinPath(parent string, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "/" + child
+}
+
+// topLevel is data definition directly under module, or under choices directly
+// under module, that contains definition
+func topLevel(m meta.Definition) meta.Definition {
+	top := m
+	for p := m.Parent(); p != nil; p = p.Parent() {
+		switch x := p.(type) {
+		case *meta.Module:
+			return top
+		case *meta.Choice, *meta.ChoiceCase:
+			// not data
+		case meta.Definition:
+			top = x
+		}
+	}
+	return top
+}
+
+// rollback undoes each operation in reverse order.  Selections are not secured
+// as user may not have access to all the data that was saved. Context is not
+// the one saved as rollback may happen long after request has ended.  An undo
+// that fails does not stop the others so as much as possible is put back.
+func (tx *transaction) rollback(ctx context.Context) error {
+	var errs []error
+	for i := len(tx.undos) - 1; i >= 0; i-- {
+		if err := tx.undos[i].restore(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks everything touched once all edits are made
+func (tx *transaction) validate() error {
+	checked := make(map[*node.Browser]map[string]bool)
+	for _, u := range tx.undos {
+		if checked[u.browser] == nil {
+			checked[u.browser] = make(map[string]bool)
+		}
+		root := dataTypeFilter(pb_gnmi.GetRequest_CONFIG).apply(u.browser.RootWithContext(tx.ctx))
+		for _, def := range u.topLevel(root) {
+			if checked[u.browser][def.Ident()] {
+				continue
+			}
+			checked[u.browser][def.Ident()] = true
+			if err := validateChild(root, def, tx.strict); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// topLevel are top-level definitions undo's path is in
+func (u *undo) topLevel(root *node.Selection) []meta.Definition {
+	if u.path == "" {
+		return childDefs(root.Meta())
+	}
+	ident := u.path
+	for i, c := range ident {
+		if c == '/' || c == '=' {
+			ident = ident[:i]
+			break
+		}
+	}
+	def := meta.Find(root.Meta(), ident)
+	if def == nil {
+		return nil
+	}
+	return []meta.Definition{topLevel(def)}
+}
+
+func (u *undo) restore(ctx context.Context) error {
+	root := u.browser.RootWithContext(ctx)
+	sel, err := findRel(root, u.path)
+	if err != nil {
+		return err
+	}
+	if u.created {
+		if sel == nil {
+			return nil
+		}
+		return deleteSel(sel)
+	}
+	if u.leaf != nil {
+		if sel == nil {
+			return fmt.Errorf("%w. %s", fc.NotFoundError, u.path)
+		}
+		if u.value == nil {
+			return sel.Parent().ClearField(u.leaf)
+		}
+		return sel.Set(u.value)
+	}
+	if u.path == "" {
+		n, err := nodeutil.ReadJSONValues(u.config.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+		return replaceRoot(root, n)
+	}
+	var parent *node.Selection
+	if sel != nil {
+		if parent = sel.Parent(); sel.InsideList {
+			// item's parent is the list
+			parent = parent.Parent()
+		}
+		if err = deleteSel(sel); err != nil {
+			return err
+		}
+	} else {
+		if parent, err = findRel(root, parentPath(u.path)); err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("%w. parent of %s", fc.NotFoundError, u.path)
+		}
+	}
+	n, err := nodeutil.ReadJSONValues(u.wrapped(parent))
+	if err != nil {
+		return err
+	}
+	return parent.UpsertFrom(n)
+}
+
+// wrapped is saved config as it would appear in parent
+func (u *undo) wrapped(parent *node.Selection) map[string]interface{} {
+	seg := u.path[len(parentPath(u.path)):]
+	if seg[0] == '/' {
+		seg = seg[1:]
+	}
+	ident := seg
+	isItem := false
+	for i, c := range seg {
+		if c == '=' {
+			ident = seg[:i]
+			isItem = true
+			break
+		}
+	}
+	if isItem {
+		return map[string]interface{}{ident: []interface{}{u.config}}
+	}
+	if _, isList := meta.Find(parent.Meta(), ident).(*meta.List); isList {
+		// lists are written w/list name
+		return u.config.(map[string]interface{})
+	}
+	return map[string]interface{}{ident: u.config}
+}
+
+func findRel(root *node.Selection, path string) (*node.Selection, error) {
+	if path == "" {
+		return root, nil
+	}
+	return root.Find(path)
+}
+
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' {
+			return path[:i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package gnmi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Authenticator verifies the username and password gNMI clients send as gRPC
+// metadata according to the gNMI authentication spec.  Return an error to
+// reject the call.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username string, password string) error
+}
+
+var errBadCredentials = errors.New("invalid username or password")
+
+// UserList is the default Authenticator and checks credentials against users
+// configured in fc-gnmi authentication.  Only password hashes are stored, see
+// HashPassword.
+type UserList struct {
+	mu     sync.RWMutex
+	hashes map[string]string
+}
+
+func NewUserList() *UserList {
+	return &UserList{
+		hashes: make(map[string]string),
+	}
+}
+
+// Set adds or updates a user with a hash from HashPassword
+func (u *UserList) Set(username string, passwordHash string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.hashes[username] = passwordHash
+}
+
+func (u *UserList) Remove(username string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.hashes, username)
+}
+
+// PasswordHash is the stored hash for user if user exists
+func (u *UserList) PasswordHash(username string) (string, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	hash, found := u.hashes[username]
+	return hash, found
+}
+
+// Usernames in sorted order
+func (u *UserList) Usernames() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	names := make([]string, 0, len(u.hashes))
+	for name := range u.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (u *UserList) Authenticate(ctx context.Context, username string, password string) error {
+	hash, found := u.PasswordHash(username)
+	if !found || !checkPassword(hash, password) {
+		return errBadCredentials
+	}
+	return nil
+}
+
+const hashPbkdf2 = "pbkdf2-sha256"
+
+// PasswordIterations is the PBKDF2 work factor for new hashes.  Each hash
+// stores the iterations it was made with so raising this does not invalidate
+// existing hashes.
+var PasswordIterations = 600000
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+// HashPassword creates a salted hash of a password suitable for storing in
+// fc-gnmi authentication/user/passwordHash. Format is
+// pbkdf2-sha256$<iterations>$<salt>$<key> with salt and key hex encoded.  The
+// leading algorithm name allows for other algorithms in the future.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, PasswordIterations, keyLen, sha256.New)
+	return fmt.Sprintf("%s$%d$%s$%s", hashPbkdf2, PasswordIterations, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashPbkdf2 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	actual := pbkdf2.Key([]byte(password), salt, iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
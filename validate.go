@@ -0,0 +1,156 @@
+package gnmi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/freeconf/yang/fc"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	"github.com/freeconf/yang/xpath"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validateTree checks mandatory, unique and must statements in config under
+// selection.  Unlike types, FreeCONF does not check these as values are written
+// as they depend on other data so they are checked after all edits are made.
+// Strict rejects data w/must statements that cannot be checked.
+func validateTree(sel *node.Selection, strict bool) error {
+	if err := checkMusts(sel, sel.Meta(), strict); err != nil {
+		return err
+	}
+	for _, def := range childDefs(sel.Meta()) {
+		if err := validateChild(sel, def, strict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateChild(sel *node.Selection, def meta.Definition, strict bool) error {
+	if !isConfig(def) {
+		return nil
+	}
+	if leaf, isLeaf := def.(meta.Leafable); isLeaf {
+		child, err := sel.Find(def.Ident())
+		if err != nil {
+			return err
+		}
+		v, err := child.Get()
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			if isMandatory(leaf) {
+				return validateErr(sel, def, "is mandatory")
+			}
+			return nil
+		}
+		return checkMusts(child, def, strict)
+	}
+	child, err := sel.Find(def.Ident())
+	if err != nil || child == nil {
+		return err
+	}
+	if lmeta, isList := def.(*meta.List); isList {
+		if err = checkUnique(child, lmeta); err != nil {
+			return err
+		}
+		item, err := child.First()
+		for ; err == nil && item.Selection != nil; item, err = item.Next() {
+			if err = validateTree(item.Selection, strict); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	return validateTree(child, strict)
+}
+
+// isMandatory is only true for leaves outside choices as leaves in cases that
+// were not chosen are not required
+func isMandatory(leaf meta.Leafable) bool {
+	if _, inCase := leaf.Parent().(*meta.ChoiceCase); inCase {
+		return false
+	}
+	if m, valid := leaf.(*meta.Leaf); valid {
+		return m.Mandatory()
+	}
+	return false
+}
+
+// checkMusts checks must statements FreeCONF can parse. FreeCONF supports a
+// limited subset of XPath so others are skipped unless strict where data that
+// cannot be checked is not accepted unchecked.
+func checkMusts(sel *node.Selection, m meta.Meta, strict bool) error {
+	hasMusts, valid := m.(meta.HasMusts)
+	if !valid {
+		return nil
+	}
+	for _, must := range hasMusts.Musts() {
+		p, err := xpath.Parse(must.Expression())
+		if err != nil {
+			if strict {
+				return status.Errorf(codes.Unimplemented, "%s. cannot check must '%s'. %s", sel.Path, must.Expression(), err)
+			}
+			fc.Debug.Printf("skipping must '%s'. %s", must.Expression(), err)
+			continue
+		}
+		satisfied, err := sel.XPredicate(p)
+		if err != nil {
+			return err
+		}
+		if !satisfied {
+			msg := must.ErrorMessage()
+			if msg == "" {
+				msg = fmt.Sprintf("must '%s' not satisfied", must.Expression())
+			}
+			return status.Errorf(codes.InvalidArgument, "%s. %s", sel.Path, msg)
+		}
+	}
+	return nil
+}
+
+func checkUnique(list *node.Selection, lmeta *meta.List) error {
+	for _, fields := range lmeta.Unique() {
+		found := make(map[string]bool)
+		item, err := list.First()
+		for ; err == nil && item.Selection != nil; item, err = item.Next() {
+			values := make([]string, len(fields))
+			complete := true
+			for i, field := range fields {
+				v, err := item.Selection.GetValue(field)
+				if err != nil {
+					return err
+				}
+				// items w/o all the fields are not compared
+				if v == nil {
+					complete = false
+					break
+				}
+				values[i] = v.String()
+			}
+			if !complete {
+				continue
+			}
+			id := strings.Join(values, "\x00")
+			if found[id] {
+				return validateErr(list, lmeta, fmt.Sprintf("has more than one item w/same %s", strings.Join(fields, ",")))
+			}
+			found[id] = true
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateErr(sel *node.Selection, def meta.Definition, msg string) error {
+	p := sel.Path
+	if p.Meta != def {
+		p = &node.Path{Parent: sel.Path, Meta: def}
+	}
+	return status.Errorf(codes.InvalidArgument, "%s %s", p, msg)
+}
@@ -0,0 +1,249 @@
+package gnmi
+
+import (
+	"context"
+	"sort"
+
+	"github.com/freeconf/restconf/device"
+	"github.com/freeconf/yang/meta"
+	"github.com/freeconf/yang/node"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// Wildcards from gNMI path conventions.  anyElem matches any single element
+// name or any key value, anyLevels matches zero or more elements
+const (
+	anyElem   = "*"
+	anyLevels = "..."
+)
+
+// pathMatch is a selection found for a path that may have wildcards along w/
+// the concrete path that was matched
+type pathMatch struct {
+	sel  *node.Selection
+	path *pb_gnmi.Path
+}
+
+func hasWildcards(path *pb_gnmi.Path) bool {
+	for _, e := range path.GetElem() {
+		if e.GetName() == anyElem || e.GetName() == anyLevels {
+			return true
+		}
+		for _, v := range e.GetKey() {
+			if v == anyElem {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectAll is like advanceSelection but expands wildcards in element names
+// and key values into every match. Paths w/o wildcards have at most one match
+// and the path is returned as given. Paths that do not exist and leaves w/o
+// values are not matches.
+func selectAll(device device.Device, ctx context.Context, prefix *node.Selection, path *pb_gnmi.Path) ([]pathMatch, error) {
+	if !hasWildcards(path) {
+		sel, missing, err := selectExisting(device, ctx, prefix, path)
+		if err != nil || len(missing) > 0 {
+			return nil, err
+		}
+		if meta.IsLeaf(sel.Meta()) {
+			if v, err := sel.Get(); err != nil || v == nil {
+				return nil, err
+			}
+		}
+		return []pathMatch{{sel: sel, path: path}}, nil
+	}
+	root := prefix
+	if root == nil {
+		if path.Origin == "" {
+			return nil, errModelOrOrigin
+		}
+		var err error
+		if root, err = selectModule(device, ctx, path.Origin); err != nil {
+			return nil, err
+		}
+	}
+	w := &wildcardWalk{path: path}
+	if err := w.expand(root, path.Elem, nil); err != nil {
+		return nil, err
+	}
+	return w.matches, nil
+}
+
+type wildcardWalk struct {
+	path    *pb_gnmi.Path
+	matches []pathMatch
+}
+
+func (w *wildcardWalk) found(sel *node.Selection, resolved []*pb_gnmi.PathElem) {
+	w.matches = append(w.matches, pathMatch{
+		sel: sel,
+		path: &pb_gnmi.Path{
+			Origin: w.path.Origin,
+			Target: w.path.Target,
+			Elem:   resolved,
+		},
+	})
+}
+
+func (w *wildcardWalk) expand(sel *node.Selection, elems []*pb_gnmi.PathElem, resolved []*pb_gnmi.PathElem) error {
+	if len(elems) == 0 {
+		w.found(sel, resolved)
+		return nil
+	}
+	seg := elems[0]
+	if seg == nil || seg.Name == "" {
+		return w.expand(sel, elems[1:], resolved)
+	}
+	switch seg.Name {
+	case anyLevels:
+		if len(elems) == 1 {
+			// everything under here is just this selection
+			w.found(sel, resolved)
+			return nil
+		}
+		if err := w.expand(sel, elems[1:], resolved); err != nil {
+			return err
+		}
+		for _, def := range childDefs(sel.Meta()) {
+			if meta.IsLeaf(def) {
+				continue
+			}
+			// elems still starts w/... so search continues at each level down
+			if err := w.expandElem(sel, &pb_gnmi.PathElem{Name: def.Ident()}, elems, resolved); err != nil {
+				return err
+			}
+		}
+		return nil
+	case anyElem:
+		for _, def := range childDefs(sel.Meta()) {
+			if err := w.expandElem(sel, &pb_gnmi.PathElem{Name: def.Ident(), Key: seg.Key}, elems[1:], resolved); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return w.expandElem(sel, seg, elems[1:], resolved)
+}
+
+// expandElem matches a single element name, names that do not exist are not
+// matches as they are likely under a wildcard
+func (w *wildcardWalk) expandElem(sel *node.Selection, seg *pb_gnmi.PathElem, rest []*pb_gnmi.PathElem, resolved []*pb_gnmi.PathElem) error {
+	def := findDef(sel.Meta(), seg.Name)
+	if def == nil {
+		return nil
+	}
+	name := def.Ident()
+	if lmeta, isList := def.(*meta.List); isList {
+		return w.expandList(sel, lmeta, seg, rest, resolved)
+	}
+	if meta.IsLeaf(def) && len(rest) > 0 {
+		return nil
+	}
+	child, err := sel.Find(name)
+	if err != nil || child == nil {
+		return err
+	}
+	if meta.IsLeaf(def) {
+		// leaves w/o values are not matches
+		if v, err := child.Get(); err != nil || v == nil {
+			return err
+		}
+	}
+	return w.expand(child, rest, appendElem(resolved, &pb_gnmi.PathElem{Name: seg.Name}))
+}
+
+// expandList matches list items. Keys omitted on a list in the middle of a
+// path, as happens when searching w/..., are treated as wildcards
+func (w *wildcardWalk) expandList(sel *node.Selection, lmeta *meta.List, seg *pb_gnmi.PathElem, rest []*pb_gnmi.PathElem, resolved []*pb_gnmi.PathElem) error {
+	wild := len(seg.Key) == 0 && len(rest) > 0
+	for _, v := range seg.Key {
+		if v == anyElem {
+			wild = true
+		}
+	}
+	if !wild {
+		ident := lmeta.Ident()
+		if len(seg.Key) > 0 {
+			ident = ident + "=" + encodeKey(lmeta, seg.Key)
+		}
+		child, err := sel.Find(ident)
+		if err != nil || child == nil {
+			return err
+		}
+		return w.expand(child, rest, appendElem(resolved, seg))
+	}
+	list, err := sel.Find(lmeta.Ident())
+	if err != nil || list == nil {
+		return err
+	}
+	item, err := list.First()
+	for ; err == nil && item.Selection != nil; item, err = item.Next() {
+		elem := &pb_gnmi.PathElem{
+			Name: seg.Name,
+			Key:  make(map[string]string, len(item.Key)),
+		}
+		for i, k := range lmeta.KeyMeta() {
+			elem.Key[k.Ident()] = item.Key[i].String()
+		}
+		if !keysMatch(seg.Key, elem.Key) {
+			continue
+		}
+		if err := w.expand(item.Selection, rest, appendElem(resolved, elem)); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func keysMatch(pattern map[string]string, keys map[string]string) bool {
+	for k, v := range pattern {
+		if v != anyElem && keys[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// childDefs are the containers, lists and leaves directly under a definition
+// including those in choice cases
+func childDefs(m meta.Meta) []meta.Definition {
+	parent, valid := m.(meta.HasDataDefinitions)
+	if !valid {
+		return nil
+	}
+	var defs []meta.Definition
+	for _, def := range parent.DataDefinitions() {
+		if choice, isChoice := def.(*meta.Choice); isChoice {
+			cases := choice.Cases()
+			idents := make([]string, 0, len(cases))
+			for ident := range cases {
+				idents = append(idents, ident)
+			}
+			sort.Strings(idents)
+			for _, ident := range idents {
+				defs = append(defs, childDefs(cases[ident])...)
+			}
+		} else if meta.IsLeaf(def) || meta.IsContainer(def) || meta.IsList(def) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// concretePath is path up to, but not including, the first wildcard
+func concretePath(path *pb_gnmi.Path) *pb_gnmi.Path {
+	if !hasWildcards(path) {
+		return path
+	}
+	concrete := &pb_gnmi.Path{Origin: path.Origin, Target: path.Target}
+	for _, e := range path.Elem {
+		if hasWildcards(&pb_gnmi.Path{Elem: []*pb_gnmi.PathElem{e}}) {
+			break
+		}
+		concrete.Elem = append(concrete.Elem, e)
+	}
+	return concrete
+}
@@ -0,0 +1,73 @@
+package gnmi
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/freeconf/yang/fc"
+	pb_gnmi "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestWildcards(t *testing.T) {
+	data := map[string]interface{}{
+		"me": map[string]interface{}{
+			"name":    "joe",
+			"address": "123 mockingbird lane.",
+		},
+		"users": []map[string]interface{}{
+			{"name": "mary", "skill": "welder", "address": "1 main st."},
+			{"name": "john", "skill": "mechanic"},
+		},
+	}
+	dev := newTestDevice(data)
+	elem := func(name string, keys ...string) *pb_gnmi.PathElem {
+		e := &pb_gnmi.PathElem{Name: name}
+		if len(keys) > 0 {
+			e.Key = map[string]string{keys[0]: keys[1]}
+		}
+		return e
+	}
+	tests := []struct {
+		elems    []*pb_gnmi.PathElem
+		expected string
+	}{
+		{
+			elems:    []*pb_gnmi.PathElem{elem("users", "name", "*"), elem("skill")},
+			expected: `/users[name=mary]/skill="welder",/users[name=john]/skill="mechanic"`,
+		},
+		{
+			elems:    []*pb_gnmi.PathElem{elem("users", "name", "mary"), elem("skill")},
+			expected: `/users[name=mary]/skill="welder"`,
+		},
+		{
+			elems:    []*pb_gnmi.PathElem{elem("..."), elem("address")},
+			expected: `/me/address="123 mockingbird lane.",/users[name=mary]/address="1 main st."`,
+		},
+		{
+			elems:    []*pb_gnmi.PathElem{elem("me"), elem("*")},
+			expected: `/me/name="joe",/me/address="123 mockingbird lane."`,
+		},
+		{
+			elems:    []*pb_gnmi.PathElem{elem("*"), elem("skill")},
+			expected: `/users[name=mary]/skill="welder",/users[name=john]/skill="mechanic"`,
+		},
+		{
+			elems:    []*pb_gnmi.PathElem{elem("users", "name", "*"), elem("nope")},
+			expected: ``,
+		},
+	}
+	for _, test := range tests {
+		req := &pb_gnmi.GetRequest{
+			UseModels: []*pb_gnmi.ModelData{{Name: "x"}},
+			Path:      []*pb_gnmi.Path{{Elem: test.elems}},
+		}
+		resp, err := get(dev, context.TODO(), req)
+		fc.RequireEqual(t, nil, err)
+		var actual []string
+		for _, u := range resp.Notification[0].Update {
+			actual = append(actual, pathString(u.Path)+"="+string(u.Val.GetJsonVal()))
+		}
+		fc.AssertEqual(t, test.expected, strings.Join(actual, ","))
+	}
+}